@@ -0,0 +1,208 @@
+package modbus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+type Access int
+
+const (
+	AccessReadWrite Access = iota
+	AccessReadOnly
+	AccessWriteOnly
+	AccessHidden
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessReadWrite:
+		return "read-write"
+	case AccessReadOnly:
+		return "read-only"
+	case AccessWriteOnly:
+		return "write-only"
+	case AccessHidden:
+		return "hidden"
+	default:
+		return "unknown"
+	}
+}
+
+// Applies to the overlapped part of a slave area.
+// Count zero covers the whole area.
+// Writes are restricted to clients matching WriteIPs (addresses
+// or CIDRs) or holding any of WriteRoles when either is set.
+// Exception zero selects 02 for access and 01 for client violations.
+type AccessRule struct {
+	Slave      byte
+	Area       byte
+	Address    uint16
+	Count      int
+	Access     Access
+	WriteIPs   []string
+	WriteRoles []string
+	Exception  byte
+}
+
+// Identity of the connected master.
+// Roles come from the TLS client certificate organizational units.
+type Client struct {
+	IP    net.IP
+	Roles []string
+}
+
+func (c *Client) String() string {
+	if c == nil {
+		return "local"
+	}
+	if len(c.Roles) > 0 {
+		return fmt.Sprintf("%s %v", c.IP, c.Roles)
+	}
+	return c.IP.String()
+}
+
+type AuditEntry struct {
+	Time      time.Time
+	Client    *Client
+	Slave     byte
+	Code      byte
+	Address   uint16
+	Count     uint16
+	Reason    string
+	Exception byte
+}
+
+func (e *AuditEntry) String() string {
+	return fmt.Sprintf("access denied %s unit=%d func=%s addr=%04x count=%d ex=%02x %s",
+		e.Client, e.Slave, CodeName(e.Code), e.Address, e.Count, e.Exception, e.Reason)
+}
+
+// Violations are reported to Audit or when nil to Logger,
+// itself defaulting to the library trace logger.
+// Commands not covered by any rule are allowed.
+type AccessPolicy struct {
+	Rules  []*AccessRule
+	Audit  func(entry *AuditEntry)
+	Logger Logger
+}
+
+// Bounds the handshake ClientOf completes so a silent
+// peer cannot hold the connection forever
+const HandshakeTimeout = 10 * time.Second
+
+// Extracts the remote IP and for TLS connections the certificate
+// roles, completing the handshake if still pending
+func ClientOf(conn net.Conn) *Client {
+	client := &Client{}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		client.IP = addr.IP
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(HandshakeTimeout))
+		err := tc.Handshake()
+		tc.SetDeadline(time.Time{})
+		if err == nil {
+			state := tc.ConnectionState()
+			if len(state.PeerCertificates) > 0 {
+				client.Roles = state.PeerCertificates[0].Subject.OrganizationalUnit
+			}
+		}
+	}
+	return client
+}
+
+// Implements: Executor
+// Enforces an access policy for a single client
+type accessExecutor struct {
+	exec   Executor
+	policy *AccessPolicy
+	client *Client
+}
+
+func (e *accessExecutor) Execute(ci *Command) (*Command, error) {
+	area := AreaOf(ci.Code)
+	write := ci.Code == WriteDo05 || ci.Code == WriteWo06 || ci.Code == WriteDos15 || ci.Code == WriteWos16
+	count := int(ci.Count())
+	for _, rule := range e.policy.Rules {
+		r := ModelRange{rule.Slave, rule.Area, rule.Address, rule.Count}
+		if area == 0 || !r.overlaps(ci.Slave, area, ci.Address, count) {
+			continue
+		}
+		reason := ""
+		exception := ExIllegalAddress02
+		switch {
+		case rule.Access == AccessHidden:
+			reason = "hidden"
+		case rule.Access == AccessReadOnly && write:
+			reason = "read-only"
+		case rule.Access == AccessWriteOnly && !write:
+			reason = "write-only"
+		case write && !e.allowed(rule):
+			reason = "client not allowed"
+			exception = ExIllegalFunction01
+		}
+		if reason == "" {
+			continue
+		}
+		if rule.Exception != 0 {
+			exception = rule.Exception
+		}
+		e.audit(ci, reason, exception)
+		return nil, &ModbusException{exception}
+	}
+	return e.exec.Execute(ci)
+}
+
+func (e *accessExecutor) allowed(rule *AccessRule) bool {
+	if len(rule.WriteIPs) == 0 && len(rule.WriteRoles) == 0 {
+		return true
+	}
+	if e.client == nil {
+		return false
+	}
+	for _, allowed := range rule.WriteIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if e.client.IP != nil && network.Contains(e.client.IP) {
+				return true
+			}
+			continue
+		}
+		if ip := net.ParseIP(allowed); ip != nil && ip.Equal(e.client.IP) {
+			return true
+		}
+	}
+	for _, role := range rule.WriteRoles {
+		for _, has := range e.client.Roles {
+			if role == has {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *accessExecutor) audit(ci *Command, reason string, exception byte) {
+	entry := &AuditEntry{}
+	entry.Time = time.Now()
+	entry.Client = e.client
+	entry.Slave = ci.Slave
+	entry.Code = ci.Code
+	entry.Address = ci.Address
+	entry.Count = ci.Count()
+	entry.Reason = reason
+	entry.Exception = exception
+	if e.policy.Audit != nil {
+		e.policy.Audit(entry)
+		return
+	}
+	orDefaultLogger(e.policy.Logger).Log(&LogEntry{
+		Time:    entry.Time,
+		Source:  "a",
+		Dir:     "<",
+		Command: ci,
+		Err:     formatErr("access denied %s ex=%02x %s", entry.Client, exception, reason),
+	})
+}
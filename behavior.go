@@ -0,0 +1,81 @@
+package modbus
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Applies to commands matching Slave and Code, zero matches any.
+// Delay plus a uniform random part up to Jitter is waited
+// before executing. NoResponse is the probability of skipping
+// the response. Every BusyEvery and AckEvery matching command
+// is answered with exception 06 and 05 respectively.
+type Behavior struct {
+	Slave      byte
+	Code       byte
+	Delay      time.Duration
+	Jitter     time.Duration
+	NoResponse float64
+	BusyEvery  int
+	AckEvery   int
+}
+
+// First matching behavior applies, the seed feeds
+// jitter and no response probabilities
+type BehaviorOptions struct {
+	Behaviors []*Behavior
+	Seed      int64
+}
+
+// Implements: Executor
+// Simulates device response timing and busy states
+type behaviorExecutor struct {
+	exec   Executor
+	opts   BehaviorOptions
+	mutex  sync.Mutex
+	rand   *rand.Rand
+	counts map[*Behavior]int
+}
+
+func (e *behaviorExecutor) Execute(ci *Command) (*Command, error) {
+	b := e.match(ci)
+	if b == nil {
+		return e.exec.Execute(ci)
+	}
+	e.mutex.Lock()
+	e.counts[b]++
+	count := e.counts[b]
+	delay := b.Delay
+	if b.Jitter > 0 {
+		delay += time.Duration(e.rand.Int63n(int64(b.Jitter) + 1))
+	}
+	skip := b.NoResponse > 0 && e.rand.Float64() < b.NoResponse
+	e.mutex.Unlock()
+	if skip {
+		return nil, ErrNoResponse
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if b.BusyEvery > 0 && count%b.BusyEvery == 0 {
+		return nil, &ModbusException{ExBusy06}
+	}
+	if b.AckEvery > 0 && count%b.AckEvery == 0 {
+		return nil, &ModbusException{ExAcknowledge05}
+	}
+	return e.exec.Execute(ci)
+}
+
+func (e *behaviorExecutor) match(ci *Command) *Behavior {
+	for _, b := range e.opts.Behaviors {
+		if b.Slave != 0 && b.Slave != ci.Slave {
+			continue
+		}
+		if b.Code != 0 && b.Code != ci.Code {
+			continue
+		}
+		return b
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Typed values read or written through the bridge.
+// Registers include the raw words, strings are a single
+// value whose count is the length in words.
+type BridgeResult struct {
+	Device  string        `json:"device"`
+	Unit    byte          `json:"unit"`
+	Area    string        `json:"area"`
+	Address uint16        `json:"address"`
+	Type    string        `json:"type"`
+	Order   string        `json:"order,omitempty"`
+	Values  []interface{} `json:"values"`
+	Raw     []uint16      `json:"raw,omitempty"`
+}
+
+type bridgeError struct {
+	Error     string `json:"error"`
+	Exception string `json:"exception,omitempty"`
+}
+
+type bridgeDevice struct {
+	mutex  *sync.Mutex
+	master Master
+	unit   byte
+}
+
+// Implements: http.Handler
+// Exposes named masters over HTTP with JSON bodies:
+//
+//	GET /devices
+//	GET /devices/{name}/{area}/{addr}?count=1&type=u16&order=ABCD&unit=1
+//	PUT /devices/{name}/{coil|hr}/{addr}?type=u16&order=ABCD&unit=1
+//
+// PUT bodies are a value, an array of values or an object
+// with a value or values field. Requests to a master are
+// serialized even when added under several names.
+// Exceptions map to HTTP statuses by meaning, timeouts
+// to 504 and other master errors to 502. Floats that are
+// NaN or infinite read as null.
+type Bridge struct {
+	mutex   sync.Mutex
+	devices map[string]*bridgeDevice
+	locks   map[Master]*sync.Mutex
+}
+
+// Unit is the default for requests without a unit parameter
+func (b *Bridge) Add(name string, master Master, unit byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	lock, ok := b.locks[master]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.locks[master] = lock
+	}
+	d := &bridgeDevice{}
+	d.mutex = lock
+	d.master = master
+	d.unit = unit
+	b.devices[name] = d
+}
+
+func (b *Bridge) device(name string) *bridgeDevice {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.devices[name]
+}
+
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "devices" {
+		if req.Method != http.MethodGet {
+			bridgeFail(w, http.StatusMethodNotAllowed, formatErr("method %s not allowed", req.Method))
+			return
+		}
+		b.mutex.Lock()
+		names := make([]string, 0, len(b.devices))
+		for name := range b.devices {
+			names = append(names, name)
+		}
+		b.mutex.Unlock()
+		sort.Strings(names)
+		bridgeReply(w, http.StatusOK, map[string]interface{}{"devices": names})
+		return
+	}
+	if len(parts) != 4 || parts[0] != "devices" {
+		bridgeFail(w, http.StatusNotFound, formatErr("path not found %s", req.URL.Path))
+		return
+	}
+	d := b.device(parts[1])
+	if d == nil {
+		bridgeFail(w, http.StatusNotFound, formatErr("device not found %s", parts[1]))
+		return
+	}
+	res, status, err := parseBridgeRequest(parts, req.URL.Query(), d.unit)
+	if err != nil {
+		bridgeFail(w, status, err)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		err = d.read(res, req.URL.Query())
+	case http.MethodPut:
+		if res.Area != AreaName(ReadDos01) && res.Area != AreaName(ReadWos03) {
+			w.Header().Set("Allow", http.MethodGet)
+			bridgeFail(w, http.StatusMethodNotAllowed, formatErr("area %s is read only", res.Area))
+			return
+		}
+		var values []string
+		values, err = bridgeValues(req)
+		if err == nil {
+			err = d.write(res, values)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		bridgeFail(w, http.StatusMethodNotAllowed, formatErr("method %s not allowed", req.Method))
+		return
+	}
+	if err != nil {
+		bridgeFail(w, BridgeStatus(err), err)
+		return
+	}
+	bridgeReply(w, http.StatusOK, res)
+}
+
+// Status for master errors, 400 for anything else
+func BridgeStatus(err error) int {
+	var me *ModbusException
+	switch {
+	case errors.As(err, &me):
+		switch me.Code {
+		case ExIllegalFunction01:
+			return http.StatusNotImplemented
+		case ExIllegalAddress02:
+			return http.StatusNotFound
+		case ExIllegalValue03:
+			return http.StatusUnprocessableEntity
+		case ExAcknowledge05, ExBusy06:
+			return http.StatusServiceUnavailable
+		case ExGatewayTarget0B:
+			return http.StatusGatewayTimeout
+		default:
+			return http.StatusBadGateway
+		}
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.As(err, new(*bridgeInputError)):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// Invalid parameters or bodies
+type bridgeInputError struct {
+	err error
+}
+
+func (e *bridgeInputError) Error() string {
+	return e.err.Error()
+}
+
+func inputErr(format string, args ...interface{}) error {
+	return &bridgeInputError{formatErr(format, args...)}
+}
+
+// Area, address, unit, type and order from the path and query
+func parseBridgeRequest(parts []string, query map[string][]string, unit byte) (*BridgeResult, int, error) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	res := &BridgeResult{Device: parts[1], Unit: unit}
+	area, err := ParseArea(parts[2])
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	res.Area = AreaName(area)
+	address, err := strconv.ParseUint(parts[3], 0, 16)
+	if err != nil {
+		return nil, http.StatusNotFound, formatErr("address invalid %q", parts[3])
+	}
+	res.Address = uint16(address)
+	if text := get("unit"); text != "" {
+		value, err := strconv.ParseUint(text, 0, 8)
+		if err != nil {
+			return nil, http.StatusBadRequest, formatErr("unit invalid %q", text)
+		}
+		res.Unit = byte(value)
+	}
+	bits := area == ReadDos01 || area == ReadDis02
+	res.Type = get("type")
+	switch {
+	case res.Type == "" && bits:
+		res.Type = TypeBool
+	case res.Type == "":
+		res.Type = TypeU16
+	case CheckType(res.Type) != nil:
+		return nil, http.StatusBadRequest, CheckType(res.Type)
+	case bits != (res.Type == TypeBool):
+		return nil, http.StatusBadRequest, formatErr("type %s invalid for area %s", res.Type, res.Area)
+	}
+	if !bits {
+		res.Order = get("order")
+		if res.Order == "" {
+			res.Order = OrderABCD
+		}
+		if err := CheckOrder(res.Order); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	return res, http.StatusOK, nil
+}
+
+// Count values fitting a single request
+func (d *bridgeDevice) read(res *BridgeResult, query map[string][]string) error {
+	count := uint64(1)
+	if values := query["count"]; len(values) > 0 {
+		var err error
+		count, err = strconv.ParseUint(values[0], 0, 16)
+		if err != nil || count == 0 {
+			return inputErr("count invalid %q", values[0])
+		}
+	}
+	area, _ := ParseArea(res.Area)
+	if res.Type == TypeBool {
+		if count > MaxBools {
+			return inputErr("count %d out of range [1, %d]", count, MaxBools)
+		}
+		var bools []bool
+		var err error
+		d.mutex.Lock()
+		if area == ReadDos01 {
+			bools, err = d.master.ReadDos(res.Unit, res.Address, uint16(count))
+		} else {
+			bools, err = d.master.ReadDis(res.Unit, res.Address, uint16(count))
+		}
+		d.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		res.Values = make([]interface{}, len(bools))
+		for i, b := range bools {
+			res.Values[i] = b
+		}
+		return nil
+	}
+	size := TypeWords(res.Type)
+	if res.Type == TypeString {
+		size, count = int(count), 1
+	}
+	if int(count)*size > MaxWords {
+		return inputErr("word count %d out of range [1, %d]", int(count)*size, MaxWords)
+	}
+	var words []uint16
+	var err error
+	d.mutex.Lock()
+	if area == ReadWos03 {
+		words, err = d.master.ReadWos(res.Unit, res.Address, uint16(int(count)*size))
+	} else {
+		words, err = d.master.ReadWis(res.Unit, res.Address, uint16(int(count)*size))
+	}
+	d.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	res.Raw = words
+	res.Values = make([]interface{}, count)
+	for i := range res.Values {
+		raw := words[i*size : (i+1)*size]
+		var value interface{}
+		if res.Type == TypeString {
+			value, err = DecodeString(res.Order, raw)
+		} else {
+			value, err = DecodeValue(res.Type, res.Order, raw)
+		}
+		if err != nil {
+			return err
+		}
+		//json has no NaN nor infinities
+		if f, ok := value.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			value = nil
+		}
+		//float32 marshals with its own precision
+		if f, ok := value.(float64); ok && res.Type == TypeF32 {
+			value = float32(f)
+		}
+		res.Values[i] = value
+	}
+	return nil
+}
+
+// Consecutive values with a single write request
+func (d *bridgeDevice) write(res *BridgeResult, texts []string) error {
+	if res.Type == TypeBool {
+		bools := make([]bool, len(texts))
+		res.Values = make([]interface{}, len(texts))
+		for i, text := range texts {
+			value, err := ParseValue(TypeBool, text)
+			if err != nil {
+				return &bridgeInputError{err}
+			}
+			bools[i] = value.(bool)
+			res.Values[i] = bools[i]
+		}
+		if len(bools) > MaxBools {
+			return inputErr("count %d out of range [1, %d]", len(bools), MaxBools)
+		}
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if len(bools) == 1 {
+			return d.master.WriteDo(res.Unit, res.Address, bools[0])
+		}
+		return d.master.WriteDos(res.Unit, res.Address, bools...)
+	}
+	words := []uint16{}
+	res.Values = make([]interface{}, len(texts))
+	for i, text := range texts {
+		value, err := ParseValue(res.Type, text)
+		var encoded []uint16
+		if err == nil && res.Type == TypeString {
+			encoded, err = EncodeString(res.Order, text, (len(text)+1)/2)
+		} else if err == nil {
+			encoded, err = EncodeValue(res.Type, res.Order, value)
+		}
+		if err != nil {
+			return &bridgeInputError{err}
+		}
+		words = append(words, encoded...)
+		res.Values[i] = value
+	}
+	if len(words) == 0 {
+		return inputErr("values encode to no words")
+	}
+	if len(words) > MaxWords {
+		return inputErr("word count %d out of range [1, %d]", len(words), MaxWords)
+	}
+	res.Raw = words
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(words) == 1 {
+		return d.master.WriteWo(res.Unit, res.Address, words[0])
+	}
+	return d.master.WriteWos(res.Unit, res.Address, words...)
+}
+
+// Body values as text for ParseValue
+func bridgeValues(req *http.Request) ([]string, error) {
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil, inputErr("body invalid: %v", err)
+	}
+	if obj, ok := body.(map[string]interface{}); ok {
+		if value, ok := obj["value"]; ok {
+			body = value
+		} else if values, ok := obj["values"]; ok {
+			body = values
+		} else {
+			return nil, inputErr("body needs a value or values field")
+		}
+	}
+	items, ok := body.([]interface{})
+	if !ok {
+		items = []interface{}{body}
+	}
+	if len(items) == 0 {
+		return nil, inputErr("body has no values")
+	}
+	texts := make([]string, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case json.Number:
+			texts[i] = v.String()
+		case bool:
+			texts[i] = strconv.FormatBool(v)
+		case string:
+			texts[i] = v
+		default:
+			return nil, inputErr("value invalid %v", item)
+		}
+	}
+	return texts, nil
+}
+
+// Encodes before writing the header so encoding
+// errors still get an error status and body
+func bridgeReply(w http.ResponseWriter, status int, body interface{}) {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		buf.Reset()
+		json.NewEncoder(buf).Encode(&bridgeError{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func bridgeFail(w http.ResponseWriter, status int, err error) {
+	body := &bridgeError{Error: ErrorMessage(err)}
+	var me *ModbusException
+	if errors.As(err, &me) {
+		body.Exception = fmt.Sprintf("%02x", me.Code)
+	}
+	bridgeReply(w, status, body)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samuelventura/go-modbus"
+)
+
+// Spec limits of a single request, below the
+// library buffer limits MaxWords and MaxBools
+const (
+	maxReadBools  = 2000
+	maxWriteBools = 1968
+	maxReadWords  = 125
+	maxWriteWords = 123
+)
+
+type clientOptions struct {
+	unit     uint
+	dtype    string
+	order    string
+	json     bool
+	watch    bool
+	interval time.Duration
+	verbose  bool
+	timeout  int
+}
+
+func clientFlags(name string, o *clientOptions) *flag.FlagSet {
+	fs := newFlagSet(name, os.Stderr)
+	fs.UintVar(&o.unit, "u", 1, "unit id")
+	fs.UintVar(&o.unit, "unit", 1, "unit id")
+	fs.StringVar(&o.dtype, "type", "", "value type: bool u16 i16 u32 i32 f32 u64 i64 f64 string")
+	fs.StringVar(&o.order, "order", modbus.OrderABCD, "byte order: ABCD CDAB BADC DCBA")
+	fs.BoolVar(&o.json, "json", false, "JSON output")
+	fs.BoolVar(&o.watch, "watch", false, "poll and refresh until interrupted")
+	fs.DurationVar(&o.interval, "interval", time.Second, "watch poll interval")
+	fs.BoolVar(&o.verbose, "v", false, "dump frames to stderr")
+	fs.IntVar(&o.timeout, "timeout", 1000, "response timeout in ms")
+	return fs
+}
+
+func (o *clientOptions) check() error {
+	if o.unit > 0xFF {
+		return fmt.Errorf("unit invalid %d", o.unit)
+	}
+	return nil
+}
+
+// Frames of this master only go to stderr when verbose
+func (o *clientOptions) trace(master modbus.Master) {
+	if lm, ok := master.(modbus.Loggable); ok && o.verbose {
+		lm.SetLogger(modbus.NewStdLogger(log.New(os.Stderr, "", 0)))
+	}
+}
+
+// Typed value at an address, raw words for registers
+type row struct {
+	Address uint16      `json:"address"`
+	Value   interface{} `json:"value"`
+	Raw     []uint16    `json:"raw,omitempty"`
+}
+
+type readResult struct {
+	Time  time.Time `json:"time"`
+	Unit  byte      `json:"unit"`
+	Area  string    `json:"area"`
+	Type  string    `json:"type"`
+	Rows  []*row    `json:"rows,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// read URL AREA ADDRESS [COUNT]
+func runRead(args []string) error {
+	o := &clientOptions{}
+	fs := clientFlags("read", o)
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 3 || len(pos) > 4 {
+		return fmt.Errorf("usage: modbus read URL AREA ADDRESS [COUNT] [flags]")
+	}
+	if err := o.check(); err != nil {
+		return err
+	}
+	dev, err := parseDevice(pos[0])
+	if err != nil {
+		return err
+	}
+	area, err := modbus.ParseArea(pos[1])
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	address, err := parseUint(pos[2], 16, "address")
+	if err != nil {
+		return err
+	}
+	count := uint64(1)
+	if len(pos) == 4 {
+		count, err = parseUint(pos[3], 16, "count")
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("count invalid %q", pos[3])
+		}
+	}
+	dtype, err := checkAreaType(area, o.dtype)
+	if err != nil {
+		return err
+	}
+	master, err := dev.openMaster(o.timeout)
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	o.trace(master)
+	poll := func() *readResult {
+		res := &readResult{Time: time.Now(), Unit: byte(o.unit), Area: modbus.AreaName(area), Type: dtype}
+		rows, err := readRows(master, byte(o.unit), area, uint16(address), int(count), dtype, o.order)
+		res.Rows = rows
+		res.Error = modbus.ErrorMessage(err)
+		return res
+	}
+	if !o.watch {
+		res := poll()
+		if res.Error != "" && !o.json {
+			return fmt.Errorf("%s", res.Error)
+		}
+		printResult(os.Stdout, res, o.json)
+		if res.Error != "" {
+			return fmt.Errorf("%s", res.Error)
+		}
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	watch(ctx, o.interval, func() {
+		res := poll()
+		if !o.json {
+			//clear screen and home cursor
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("%s every %s unit %d %s %d\n\n", res.Time.Format("15:04:05"), o.interval, o.unit, res.Area, address)
+		}
+		printResult(os.Stdout, res, o.json)
+	})
+	return nil
+}
+
+// Runs step every interval until ctx is done
+func watch(ctx context.Context, interval time.Duration, step func()) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		step()
+		timer.Reset(interval)
+	}
+}
+
+// write URL AREA ADDRESS VALUE...
+func runWrite(args []string) error {
+	o := &clientOptions{}
+	fs := clientFlags("write", o)
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 4 {
+		return fmt.Errorf("usage: modbus write URL AREA ADDRESS VALUE... [flags]")
+	}
+	if err := o.check(); err != nil {
+		return err
+	}
+	dev, err := parseDevice(pos[0])
+	if err != nil {
+		return err
+	}
+	area, err := modbus.ParseArea(pos[1])
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	if area != modbus.ReadDos01 && area != modbus.ReadWos03 {
+		return fmt.Errorf("area %s is read only", modbus.AreaName(area))
+	}
+	address, err := parseUint(pos[2], 16, "address")
+	if err != nil {
+		return err
+	}
+	dtype, err := checkAreaType(area, o.dtype)
+	if err != nil {
+		return err
+	}
+	master, err := dev.openMaster(o.timeout)
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	o.trace(master)
+	count, err := writeValues(master, byte(o.unit), area, uint16(address), dtype, o.order, pos[3:])
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	if o.json {
+		return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"unit": o.unit, "area": modbus.AreaName(area), "address": address, "count": count,
+		})
+	}
+	fmt.Printf("wrote %d addresses at %d\n", count, address)
+	return nil
+}
+
+// Defaults to bool for coils and inputs, u16 for registers
+func checkAreaType(area byte, dtype string) (string, error) {
+	bits := area == modbus.ReadDos01 || area == modbus.ReadDis02
+	if dtype == "" {
+		if bits {
+			return modbus.TypeBool, nil
+		}
+		return modbus.TypeU16, nil
+	}
+	if err := modbus.CheckType(dtype); err != nil {
+		return "", fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	if bits != (dtype == modbus.TypeBool) {
+		return "", fmt.Errorf("type %s invalid for area %s", dtype, modbus.AreaName(area))
+	}
+	return dtype, nil
+}
+
+// Count is the number of values except for strings
+// where it is the length in words
+func readRows(master modbus.Master, unit byte, area byte, address uint16, count int, dtype, order string) ([]*row, error) {
+	if err := modbus.CheckOrder(order); err != nil {
+		return nil, err
+	}
+	if dtype == modbus.TypeBool {
+		bools, err := readBools(master, unit, area, address, count)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]*row, len(bools))
+		for i, b := range bools {
+			rows[i] = &row{Address: address + uint16(i), Value: b}
+		}
+		return rows, nil
+	}
+	size := modbus.TypeWords(dtype)
+	if dtype == modbus.TypeString {
+		size, count = count, 1
+	}
+	words, err := readWords(master, unit, area, address, count*size)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*row, count)
+	for i := range rows {
+		raw := words[i*size : (i+1)*size]
+		value, err := decodeRow(dtype, order, raw)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = &row{Address: address + uint16(i*size), Value: value, Raw: raw}
+	}
+	return rows, nil
+}
+
+// Floats keep their precision when printed
+func decodeRow(dtype, order string, raw []uint16) (interface{}, error) {
+	if dtype == modbus.TypeString {
+		return modbus.DecodeString(order, raw)
+	}
+	value, err := modbus.DecodeValue(dtype, order, raw)
+	if err != nil {
+		return nil, err
+	}
+	if dtype == modbus.TypeF32 {
+		return float32(value.(float64)), nil
+	}
+	return value, nil
+}
+
+// Splits in requests of at most maxReadBools
+func readBools(master modbus.Master, unit byte, area byte, address uint16, count int) ([]bool, error) {
+	if int(address)+count > 0x10000 {
+		return nil, fmt.Errorf("address range %d+%d overflows", address, count)
+	}
+	bools := []bool{}
+	for done := 0; done < count; {
+		n := count - done
+		if n > maxReadBools {
+			n = maxReadBools
+		}
+		var chunk []bool
+		var err error
+		if area == modbus.ReadDos01 {
+			chunk, err = master.ReadDos(unit, address+uint16(done), uint16(n))
+		} else {
+			chunk, err = master.ReadDis(unit, address+uint16(done), uint16(n))
+		}
+		if err != nil {
+			return nil, err
+		}
+		bools = append(bools, chunk...)
+		done += n
+	}
+	return bools, nil
+}
+
+// Splits in requests of at most maxReadWords
+func readWords(master modbus.Master, unit byte, area byte, address uint16, count int) ([]uint16, error) {
+	if int(address)+count > 0x10000 {
+		return nil, fmt.Errorf("address range %d+%d overflows", address, count)
+	}
+	words := []uint16{}
+	for done := 0; done < count; {
+		n := count - done
+		if n > maxReadWords {
+			n = maxReadWords
+		}
+		var chunk []uint16
+		var err error
+		if area == modbus.ReadWos03 {
+			chunk, err = master.ReadWos(unit, address+uint16(done), uint16(n))
+		} else {
+			chunk, err = master.ReadWis(unit, address+uint16(done), uint16(n))
+		}
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, chunk...)
+		done += n
+	}
+	return words, nil
+}
+
+// Returns the count of addresses written
+func writeValues(master modbus.Master, unit byte, area byte, address uint16, dtype, order string, texts []string) (int, error) {
+	if area == modbus.ReadDos01 {
+		bools, err := parseBools(texts)
+		if err != nil {
+			return 0, err
+		}
+		if len(bools) > maxWriteBools {
+			return 0, fmt.Errorf("count %d out of range [1, %d]", len(bools), maxWriteBools)
+		}
+		if len(bools) == 1 {
+			return 1, master.WriteDo(unit, address, bools[0])
+		}
+		return len(bools), master.WriteDos(unit, address, bools...)
+	}
+	words, err := encodeWords(dtype, order, texts)
+	if err != nil {
+		return 0, err
+	}
+	if len(words) > maxWriteWords {
+		return 0, fmt.Errorf("word count %d out of range [1, %d]", len(words), maxWriteWords)
+	}
+	if len(words) == 1 {
+		return 1, master.WriteWo(unit, address, words[0])
+	}
+	return len(words), master.WriteWos(unit, address, words...)
+}
+
+func parseBools(texts []string) ([]bool, error) {
+	bools := make([]bool, len(texts))
+	for i, text := range texts {
+		value, err := modbus.ParseValue(modbus.TypeBool, text)
+		if err != nil {
+			return nil, err
+		}
+		bools[i] = value.(bool)
+	}
+	return bools, nil
+}
+
+// Consecutive values packed by type and order
+func encodeWords(dtype, order string, texts []string) ([]uint16, error) {
+	words := []uint16{}
+	for _, text := range texts {
+		var encoded []uint16
+		value, err := modbus.ParseValue(dtype, text)
+		if err == nil && dtype == modbus.TypeString {
+			encoded, err = modbus.EncodeString(order, text, (len(text)+1)/2)
+		} else if err == nil {
+			encoded, err = modbus.EncodeValue(dtype, order, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, encoded...)
+	}
+	return words, nil
+}
+
+func printResult(w io.Writer, res *readResult, asJson bool) {
+	if asJson {
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if res.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", res.Error)
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	bits := res.Type == modbus.TypeBool
+	if bits {
+		fmt.Fprintln(tw, "ADDRESS\tVALUE")
+	} else {
+		fmt.Fprintln(tw, "ADDRESS\tVALUE\tRAW")
+	}
+	for _, r := range res.Rows {
+		if bits {
+			fmt.Fprintf(tw, "%d\t%v\n", r.Address, r.Value)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Address, formatValue(r.Value), modbus.HexWords(r.Raw))
+	}
+	tw.Flush()
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(value)
+}
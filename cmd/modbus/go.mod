@@ -0,0 +1,16 @@
+module github.com/samuelventura/go-modbus/cmd/modbus
+
+go 1.17
+
+require (
+	github.com/samuelventura/go-modbus v0.0.0
+	go.bug.st/serial v1.6.4
+)
+
+require (
+	github.com/creack/goselect v0.1.2 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/samuelventura/go-modbus => ../..
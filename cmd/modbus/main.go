@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: modbus COMMAND [ARGS] [FLAGS]
+
+commands:
+  read URL AREA ADDRESS [COUNT]   read values, COUNT is the string length in words for strings
+  write URL AREA ADDRESS VALUE... write values, multiple values write consecutive addresses
+  serve URL                       run a slave, type help at its prompt to change inputs
+  sniff [SOURCE]                  decode frames from a hex dump, raw file, stdin or a live tap
+
+urls:
+  tcp://host[:502]
+  rtu+tcp://host:port
+  rtu:///dev/ttyUSB0?baud=9600&data=8&parity=N&stop=1
+
+areas: coil di hr ir
+
+examples:
+  modbus read tcp://10.0.0.5:502 -u 1 hr 100 10 --type f32 --order CDAB
+  modbus write rtu:///dev/ttyUSB0?baud=9600 -u 2 coil 4 1
+  modbus read tcp://10.0.0.5 ir 0 8 --watch --interval 500ms
+  modbus serve tcp://0.0.0.0:5020 -map plant.yaml
+  modbus sniff rtu:///dev/ttyUSB1?baud=19200
+  modbus sniff dump.txt -framing tcp --json
+
+run modbus COMMAND -h for the command flags
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "read":
+		err = runRead(os.Args[2:])
+	case "write":
+		err = runWrite(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "sniff":
+		err = runSniff(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "command unknown %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
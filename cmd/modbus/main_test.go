@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samuelventura/go-modbus"
+	"go.bug.st/serial"
+)
+
+func TestParseDevice(t *testing.T) {
+	dev, err := parseDevice("tcp://10.0.0.5")
+	fatalIfError(t, err)
+	if dev.scheme != "tcp" || dev.addr != "10.0.0.5:502" {
+		t.Fatalf("device mismatch %+v", dev)
+	}
+	dev, err = parseDevice("rtu+tcp://gw:4001")
+	fatalIfError(t, err)
+	if dev.scheme != "rtu+tcp" || dev.addr != "gw:4001" {
+		t.Fatalf("device mismatch %+v", dev)
+	}
+	dev, err = parseDevice("rtu:///dev/ttyUSB0?baud=19200&parity=e&stop=2")
+	fatalIfError(t, err)
+	mode := serial.Mode{BaudRate: 19200, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.TwoStopBits}
+	if dev.addr != "/dev/ttyUSB0" || *dev.mode != mode {
+		t.Fatalf("device mismatch %+v %+v", dev, dev.mode)
+	}
+	dev, err = parseDevice("rtu://COM3")
+	fatalIfError(t, err)
+	if dev.addr != "COM3" || dev.mode.BaudRate != 9600 || dev.mode.Parity != serial.NoParity {
+		t.Fatalf("device mismatch %+v %+v", dev, dev.mode)
+	}
+	for _, raw := range []string{
+		"udp://host:502",
+		"rtu:///dev/ttyS0?baud=fast",
+		"rtu:///dev/ttyS0?parity=X",
+		"rtu:///dev/ttyS0?stop=3",
+		"rtu://",
+	} {
+		if _, err := parseDevice(raw); err == nil {
+			t.Fatalf("error expected for %s", raw)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	o := &clientOptions{}
+	pos, err := parseArgs(clientFlags("read", o), []string{
+		"tcp://host", "-u", "2", "hr", "--type=i16", "-1", "-json", "--", "-v"})
+	fatalIfError(t, err)
+	assertStrings(t, pos, []string{"tcp://host", "hr", "-1", "-v"})
+	if o.unit != 2 || o.dtype != modbus.TypeI16 || !o.json || o.verbose {
+		t.Fatalf("options mismatch %+v", o)
+	}
+	fatalIfError(t, o.check())
+	for _, args := range [][]string{
+		{"-x"},
+		{"-u"},
+		{"-u", "one"},
+	} {
+		if _, err := parseArgs(clientFlags("read", &clientOptions{}), args); err == nil {
+			t.Fatalf("error expected for %v", args)
+		}
+	}
+	o = &clientOptions{}
+	_, err = parseArgs(clientFlags("read", o), []string{"-u", "256"})
+	fatalIfError(t, err)
+	if o.check() == nil {
+		t.Fatalf("unit error expected")
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	model := modbus.NewMapModel()
+	exec := &countExecutor{exec: modbus.NewModelExecutor(model)}
+	master := modbus.NewCloseableMaster(exec, nil)
+	defer master.Close()
+	f32, err := modbus.EncodeValue(modbus.TypeF32, modbus.OrderCDAB, 1.5)
+	fatalIfError(t, err)
+	model.WriteWos(1, 10, f32...)
+	model.WriteWos(1, 12, 0xFFFF)
+	name, err := modbus.EncodeString(modbus.OrderABCD, "AB3", 2)
+	fatalIfError(t, err)
+	model.WriteWis(1, 20, name...)
+	model.WriteDos(1, 4, true, false, true)
+	rows, err := readRows(master, 1, modbus.ReadWos03, 10, 1, modbus.TypeF32, modbus.OrderCDAB)
+	fatalIfError(t, err)
+	if len(rows) != 1 || rows[0].Value != float32(1.5) || len(rows[0].Raw) != 2 {
+		t.Fatalf("rows mismatch %+v", rows[0])
+	}
+	rows, err = readRows(master, 1, modbus.ReadWos03, 12, 1, modbus.TypeI16, modbus.OrderABCD)
+	fatalIfError(t, err)
+	if rows[0].Value != int64(-1) {
+		t.Fatalf("value mismatch %v", rows[0].Value)
+	}
+	rows, err = readRows(master, 1, modbus.ReadWis04, 20, 2, modbus.TypeString, modbus.OrderABCD)
+	fatalIfError(t, err)
+	if len(rows) != 1 || rows[0].Value != "AB3" {
+		t.Fatalf("string mismatch %+v", rows)
+	}
+	rows, err = readRows(master, 1, modbus.ReadDos01, 4, 3, modbus.TypeBool, "")
+	fatalIfError(t, err)
+	if len(rows) != 3 || rows[2].Address != 6 || rows[2].Value != true {
+		t.Fatalf("bools mismatch %+v", rows[2])
+	}
+	exec.max = 0
+	rows, err = readRows(master, 1, modbus.ReadWos03, 0, 300, modbus.TypeU16, modbus.OrderABCD)
+	fatalIfError(t, err)
+	if len(rows) != 300 || rows[12].Value != uint64(0xFFFF) || exec.max != maxReadWords {
+		t.Fatalf("chunks mismatch %d %v %d", len(rows), rows[12].Value, exec.max)
+	}
+	_, err = readRows(master, 1, modbus.ReadWos03, 0xFFFF, 2, modbus.TypeU16, modbus.OrderABCD)
+	if err == nil {
+		t.Fatalf("overflow error expected")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	steps := 0
+	done := make(chan bool)
+	go func() {
+		watch(ctx, time.Millisecond, func() {
+			steps++
+			if steps == 3 {
+				cancel()
+			}
+		})
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("watch did not stop")
+	}
+	if steps != 3 {
+		t.Fatalf("steps mismatch %d", steps)
+	}
+	err := runRead([]string{"tcp://127.0.0.1:1", "hr", "0", "0"})
+	if err == nil || !strings.Contains(err.Error(), "count invalid") {
+		t.Fatalf("count error expected: %v", err)
+	}
+}
+
+func TestEncodeWords(t *testing.T) {
+	words, err := encodeWords(modbus.TypeU16, modbus.OrderABCD, []string{"1", "0x10"})
+	fatalIfError(t, err)
+	assertWords(t, words, []uint16{1, 0x10})
+	words, err = encodeWords(modbus.TypeI16, modbus.OrderABCD, []string{"-2"})
+	fatalIfError(t, err)
+	assertWords(t, words, []uint16{0xFFFE})
+	words, err = encodeWords(modbus.TypeF32, modbus.OrderCDAB, []string{"1.5"})
+	fatalIfError(t, err)
+	assertWords(t, words, []uint16{0x0000, 0x3FC0})
+	words, err = encodeWords(modbus.TypeString, modbus.OrderABCD, []string{"AB3"})
+	fatalIfError(t, err)
+	assertWords(t, words, []uint16{0x4142, 0x3300})
+	for _, text := range []string{"70000", "x", "-1"} {
+		if _, err := encodeWords(modbus.TypeU16, modbus.OrderABCD, []string{text}); err == nil {
+			t.Fatalf("error expected for %s", text)
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	model := modbus.NewMapModel()
+	output := &bytes.Buffer{}
+	if runConsole(strings.NewReader("set hr 3 7 8\nget -u 2 hr 3\n"), output, model, 1) {
+		t.Fatalf("end of input is not quit")
+	}
+	assertWords(t, model.ReadWos(1, 3, 2), []uint16{7, 8})
+	if !runConsole(strings.NewReader("bogus\nquit\nset hr 0 1\n"), output, model, 1) {
+		t.Fatalf("quit expected")
+	}
+	assertWords(t, model.ReadWos(1, 0, 1), []uint16{0})
+	if !strings.Contains(output.String(), "command unknown") {
+		t.Fatalf("output mismatch %s", output.String())
+	}
+}
+
+// Records the largest count requested
+type countExecutor struct {
+	exec modbus.Executor
+	max  uint16
+}
+
+func (e *countExecutor) Execute(ci *modbus.Command) (*modbus.Command, error) {
+	if ci.Corv > e.max {
+		e.max = ci.Corv
+	}
+	return e.exec.Execute(ci)
+}
+
+func fatalIfError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertStrings(t *testing.T, a, b []string) {
+	t.Helper()
+	if len(a) != len(b) {
+		t.Fatalf("len mismatch %v %v", a, b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("mismatch at %d %q %q", i, a[i], b[i])
+		}
+	}
+}
+
+func assertWords(t *testing.T, a, b []uint16) {
+	t.Helper()
+	if len(a) != len(b) {
+		t.Fatalf("len mismatch %04x %04x", a, b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("mismatch at %d %04x %04x", i, a[i], b[i])
+		}
+	}
+}
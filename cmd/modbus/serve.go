@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samuelventura/go-modbus"
+)
+
+const consoleHelp = `commands:
+  get [-u UNIT] AREA ADDRESS [COUNT] [--type T] [--order O]
+  set [-u UNIT] AREA ADDRESS VALUE... [--type T] [--order O]
+  help
+  quit
+`
+
+type serveOptions struct {
+	mapPath string
+	units   string
+	quiet   bool
+	verbose bool
+	console bool
+}
+
+// serve URL
+func runServe(args []string) error {
+	o := &serveOptions{}
+	fs := newFlagSet("serve", os.Stderr)
+	fs.StringVar(&o.mapPath, "map", "", "register map file .yaml .yml or .csv")
+	fs.StringVar(&o.units, "units", "", "comma separated units answered on serial buses, default map units")
+	fs.BoolVar(&o.quiet, "q", false, "do not log requests")
+	fs.BoolVar(&o.verbose, "v", false, "log raw frames")
+	fs.BoolVar(&o.console, "console", isTerminal(os.Stdin), "read commands from stdin, default when it is a terminal")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: modbus serve URL [flags]")
+	}
+	dev, err := parseDevice(pos[0])
+	if err != nil {
+		return err
+	}
+	model, units, err := loadModel(o.mapPath)
+	if err != nil {
+		return err
+	}
+	if o.units != "" {
+		units, err = parseUnits(o.units)
+		if err != nil {
+			return err
+		}
+	}
+	logger := modbus.NewStdLogger(log.New(os.Stdout, "", 0))
+	var exec modbus.Executor = modbus.NewModelExecutor(model)
+	if !o.quiet {
+		exec = modbus.Chain(exec, modbus.LoggingMiddleware(logger))
+	}
+	stop := make(chan struct{}, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		stop <- struct{}{}
+	}()
+	defaultUnit := byte(1)
+	if len(units) > 0 {
+		defaultUnit = units[0]
+	}
+	//stdin reaching its end leaves the server running
+	if o.console {
+		go func() {
+			if runConsole(os.Stdin, os.Stdout, model, defaultUnit) {
+				stop <- struct{}{}
+			}
+		}()
+	}
+	switch dev.scheme {
+	case "rtu":
+		trans, err := openSerial(dev.addr, dev.mode)
+		if err != nil {
+			return err
+		}
+		if o.verbose {
+			trans.(modbus.Loggable).SetLogger(logger)
+		}
+		proto := modbus.NewRtuProtocol()
+		if len(units) > 0 {
+			proto = modbus.NewRtuMultidropProtocol(units...)
+		}
+		go func() {
+			<-stop
+			trans.Close()
+		}()
+		fmt.Printf("serving rtu on %s units %v\n", dev.addr, units)
+		err = modbus.RunSlave(proto, trans, exec)
+		if errors.Is(err, os.ErrClosed) {
+			return nil
+		}
+		return err
+	default:
+		opts := modbus.ServerOptions{}
+		if o.verbose {
+			opts.Logger = logger
+		}
+		server := modbus.NewServer(dev.protocol, exec, opts)
+		go func() {
+			<-stop
+			server.Close()
+		}()
+		fmt.Printf("serving %s on %s\n", dev.scheme, dev.addr)
+		err = server.ListenAndServe(dev.addr)
+		if errors.Is(err, modbus.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// Map model and its sorted units, empty map model without path
+func loadModel(path string) (modbus.Model, []byte, error) {
+	if path == "" {
+		return modbus.NewMapModel(), nil, nil
+	}
+	rm, err := modbus.LoadRegisterMap(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	model, err := modbus.NewRegisterModel(rm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	seen := make(map[byte]bool)
+	units := []byte{}
+	for _, reg := range rm.Registers {
+		if !seen[reg.Slave] {
+			seen[reg.Slave] = true
+			units = append(units, reg.Slave)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i] < units[j] })
+	return model, units, nil
+}
+
+func parseUnits(text string) ([]byte, error) {
+	units := []byte{}
+	for _, field := range strings.Split(text, ",") {
+		unit, err := strconv.ParseUint(strings.TrimSpace(field), 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unit invalid %q", field)
+		}
+		units = append(units, byte(unit))
+	}
+	return units, nil
+}
+
+// Reads commands until quit or end of input,
+// true only when quit was typed
+func runConsole(input io.Reader, output io.Writer, model modbus.Model, unit byte) (quit bool) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "get":
+			err = consoleGet(output, model, unit, fields[1:])
+		case "set":
+			err = consoleSet(output, model, unit, fields[1:])
+		case "help", "?":
+			fmt.Fprint(output, consoleHelp)
+		case "quit", "exit":
+			return true
+		default:
+			err = fmt.Errorf("command unknown %q, try help", fields[0])
+		}
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(output, "error: %s\n", err)
+		}
+	}
+	return false
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type consoleOptions struct {
+	unit  uint
+	dtype string
+	order string
+}
+
+func consoleArgs(name string, output io.Writer, unit byte, args []string) (*consoleOptions, []string, error) {
+	o := &consoleOptions{}
+	fs := newFlagSet(name, output)
+	fs.UintVar(&o.unit, "u", uint(unit), "unit id")
+	fs.UintVar(&o.unit, "unit", uint(unit), "unit id")
+	fs.StringVar(&o.dtype, "type", "", "value type")
+	fs.StringVar(&o.order, "order", modbus.OrderABCD, "byte order")
+	pos, err := parseArgs(fs, args)
+	return o, pos, err
+}
+
+func consoleGet(output io.Writer, model modbus.Model, unit byte, args []string) error {
+	o, pos, err := consoleArgs("get", output, unit, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 2 || len(pos) > 3 {
+		return fmt.Errorf("usage: get AREA ADDRESS [COUNT]")
+	}
+	area, address, dtype, err := consoleTarget(pos, o)
+	if err != nil {
+		return err
+	}
+	count := uint64(1)
+	if len(pos) == 3 {
+		count, err = parseUint(pos[2], 16, "count")
+		if err != nil {
+			return err
+		}
+	}
+	master := modbus.NewCloseableMaster(modbus.NewModelExecutor(model), nil)
+	rows, err := readRows(master, byte(o.unit), area, address, int(count), dtype, o.order)
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	printResult(output, &readResult{Type: dtype, Rows: rows}, false)
+	return nil
+}
+
+// Writes straight to the model so inputs can change too
+func consoleSet(output io.Writer, model modbus.Model, unit byte, args []string) error {
+	o, pos, err := consoleArgs("set", output, unit, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 3 {
+		return fmt.Errorf("usage: set AREA ADDRESS VALUE...")
+	}
+	area, address, dtype, err := consoleTarget(pos, o)
+	if err != nil {
+		return err
+	}
+	slave := byte(o.unit)
+	if dtype == modbus.TypeBool {
+		bools, err := parseBools(pos[2:])
+		if err != nil {
+			return fmt.Errorf("%s", modbus.ErrorMessage(err))
+		}
+		if !contains(model, slave, area, address, len(bools)) {
+			return fmt.Errorf("address range %d+%d not in model", address, len(bools))
+		}
+		if area == modbus.ReadDos01 {
+			model.WriteDos(slave, address, bools...)
+		} else {
+			model.WriteDis(slave, address, bools...)
+		}
+		return nil
+	}
+	words, err := encodeWords(dtype, o.order, pos[2:])
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	if !contains(model, slave, area, address, len(words)) {
+		return fmt.Errorf("address range %d+%d not in model", address, len(words))
+	}
+	if area == modbus.ReadWos03 {
+		model.WriteWos(slave, address, words...)
+	} else {
+		model.WriteWis(slave, address, words...)
+	}
+	return nil
+}
+
+func consoleTarget(pos []string, o *consoleOptions) (area byte, address uint16, dtype string, err error) {
+	if o.unit > 0xFF {
+		err = fmt.Errorf("unit invalid %d", o.unit)
+		return
+	}
+	area, err = modbus.ParseArea(pos[0])
+	if err != nil {
+		err = fmt.Errorf("%s", modbus.ErrorMessage(err))
+		return
+	}
+	value, err := parseUint(pos[1], 16, "address")
+	if err != nil {
+		return
+	}
+	address = uint16(value)
+	dtype, err = checkAreaType(area, o.dtype)
+	return
+}
+
+func contains(model modbus.Model, slave byte, area byte, address uint16, count int) bool {
+	if int(address)+count > 0x10000 {
+		return false
+	}
+	if bm, ok := model.(modbus.BoundedModel); ok {
+		return bm.Contains(slave, area, address, uint16(count))
+	}
+	return true
+}
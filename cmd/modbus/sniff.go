@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/samuelventura/go-modbus"
+	"go.bug.st/serial"
+)
+
+// Read timeout of live taps, a timeout ends the current frame
+const tapTimeout = 10 * time.Millisecond
+
+type sniffOptions struct {
+	framing string
+	baud    int
+	format  string
+	json    bool
+}
+
+// Frame fields with the command ones omitted when not decoded
+type sniffRecord struct {
+	Time      string   `json:"time,omitempty"`
+	Kind      string   `json:"kind"`
+	Tid       *uint16  `json:"tid,omitempty"`
+	Unit      *byte    `json:"unit,omitempty"`
+	Function  string   `json:"function,omitempty"`
+	Address   *uint16  `json:"address,omitempty"`
+	Corv      *uint16  `json:"corv,omitempty"`
+	Bools     []bool   `json:"bools,omitempty"`
+	Words     []uint16 `json:"words,omitempty"`
+	Exception string   `json:"exception,omitempty"`
+	AfterUs   *int64   `json:"after_us,omitempty"`
+	Frame     string   `json:"frame"`
+	Issues    []string `json:"issues,omitempty"`
+}
+
+// sniff [SOURCE]
+func runSniff(args []string) error {
+	o := &sniffOptions{}
+	fs := newFlagSet("sniff", os.Stderr)
+	fs.StringVar(&o.framing, "framing", modbus.FramingRtu, "framing: rtu ascii tcp")
+	fs.IntVar(&o.baud, "baud", -1, "rtu timing checks baud rate, 0 disables, default the serial port baud")
+	fs.StringVar(&o.format, "format", "", "file input: hex or raw, default raw for ascii and hex otherwise")
+	fs.BoolVar(&o.json, "json", false, "JSON output")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 1 {
+		return fmt.Errorf("usage: modbus sniff [SOURCE] [flags]")
+	}
+	source := "-"
+	if len(pos) == 1 {
+		source = pos[0]
+	}
+	emit := func(frames []*modbus.Frame) {
+		for _, f := range frames {
+			printFrame(os.Stdout, f, o)
+		}
+	}
+	if strings.Contains(source, "://") {
+		return sniffTap(source, o, emit)
+	}
+	if o.baud < 0 {
+		o.baud = 0
+	}
+	decoder, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: o.framing, Baud: o.baud})
+	if err != nil {
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	input := os.Stdin
+	if source != "-" {
+		input, err = os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+	}
+	format := o.format
+	if format == "" {
+		format = "hex"
+		if o.framing == modbus.FramingAscii {
+			format = "raw"
+		}
+	}
+	switch format {
+	case "hex":
+		err = feedHex(input, decoder, emit)
+	case "raw":
+		err = feedRaw(input, decoder, emit)
+	default:
+		return fmt.Errorf("format unsupported %q", format)
+	}
+	emit(decoder.Flush())
+	return err
+}
+
+// One chunk per line, # starts a comment and
+// separators like spaces, commas, colons or 0x are ignored
+func feedHex(input io.Reader, decoder *modbus.Decoder, emit func([]*modbus.Frame)) error {
+	scanner := bufio.NewScanner(input)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.ReplaceAll(text, "0x", "")
+		text = strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '\t', ',', ':', '-':
+				return -1
+			}
+			return r
+		}, text)
+		if text == "" {
+			continue
+		}
+		data, err := hex.DecodeString(text)
+		if err != nil {
+			return fmt.Errorf("line %d hex invalid %q", line, text)
+		}
+		emit(decoder.Feed(time.Time{}, data))
+	}
+	return scanner.Err()
+}
+
+func feedRaw(input io.Reader, decoder *modbus.Decoder, emit func([]*modbus.Frame)) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := input.Read(buf)
+		emit(decoder.Feed(time.Time{}, buf[:n]))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Passive read of a serial line or of a transparent
+// RS-485 to TCP converter until interrupted
+func sniffTap(source string, o *sniffOptions, emit func([]*modbus.Frame)) error {
+	dev, err := parseDevice(source)
+	if err != nil {
+		return err
+	}
+	var read func(buf []byte) (int, error)
+	var closer io.Closer
+	switch dev.scheme {
+	case "rtu":
+		port, err := serial.Open(dev.addr, dev.mode)
+		if err != nil {
+			return err
+		}
+		err = port.SetReadTimeout(tapTimeout)
+		if err != nil {
+			port.Close()
+			return err
+		}
+		if o.baud < 0 {
+			o.baud = dev.mode.BaudRate
+		}
+		read, closer = port.Read, port
+	case "rtu+tcp":
+		conn, err := net.Dial("tcp", dev.addr)
+		if err != nil {
+			return err
+		}
+		read = func(buf []byte) (int, error) {
+			conn.SetReadDeadline(time.Now().Add(tapTimeout))
+			n, err := conn.Read(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				err = nil
+			}
+			return n, err
+		}
+		closer = conn
+	default:
+		return fmt.Errorf("scheme %s cannot be tapped, sniff a capture file instead", dev.scheme)
+	}
+	if o.baud < 0 {
+		o.baud = 0
+	}
+	decoder, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: o.framing, Baud: o.baud})
+	if err != nil {
+		closer.Close()
+		return fmt.Errorf("%s", modbus.ErrorMessage(err))
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		<-signals
+		close(done)
+		closer.Close()
+	}()
+	var char time.Duration
+	if o.baud > 0 {
+		char = 11 * time.Second / time.Duration(o.baud)
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, err := read(buf)
+		now := time.Now()
+		if n > 0 {
+			//reads return after the last byte
+			emit(decoder.Feed(now.Add(-time.Duration(n)*char), buf[:n]))
+		} else if err == nil {
+			emit(decoder.Flush())
+		}
+		if err != nil {
+			emit(decoder.Flush())
+			select {
+			case <-done:
+				return nil
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func printFrame(w io.Writer, f *modbus.Frame, o *sniffOptions) {
+	if !o.json {
+		if o.framing == modbus.FramingTcp && len(f.Pdu) > 0 {
+			fmt.Fprintf(w, "tid=%04x ", f.Tid)
+		}
+		fmt.Fprintln(w, f.String())
+		return
+	}
+	r := &sniffRecord{Kind: "unparsed", Frame: hex.EncodeToString(f.Raw), Issues: f.Issues}
+	if !f.Time.IsZero() {
+		r.Time = f.Time.Format(time.RFC3339Nano)
+	}
+	if len(f.Pdu) >= 2 {
+		r.Kind = "request"
+		if f.Response {
+			r.Kind = "response"
+		}
+		r.Unit = &f.Pdu[0]
+		r.Function = modbus.CodeName(f.Pdu[1])
+	}
+	if o.framing == modbus.FramingTcp && len(f.Pdu) > 0 {
+		r.Tid = &f.Tid
+	}
+	if c := f.Command; c != nil {
+		r.Address, r.Corv = &c.Address, &c.Corv
+		r.Bools, r.Words = c.Bools, c.Words
+	}
+	if f.Response && f.Exception != 0 {
+		r.Exception = fmt.Sprintf("%02x", f.Exception)
+	}
+	if f.Request != nil && !f.Time.IsZero() && !f.Request.Time.IsZero() {
+		after := f.Time.Sub(f.Request.Time).Microseconds()
+		r.AfterUs = &after
+	}
+	json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuelventura/go-modbus"
+	"go.bug.st/serial"
+)
+
+// Device URLs:
+//
+//	tcp://host[:502]          Modbus TCP
+//	rtu+tcp://host:port       RTU frames over a TCP socket
+//	rtu:///dev/ttyUSB0?baud=9600&data=8&parity=N&stop=1
+type device struct {
+	scheme string
+	addr   string
+	mode   *serial.Mode
+}
+
+func parseDevice(raw string) (*device, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	d := &device{scheme: strings.ToLower(u.Scheme)}
+	switch d.scheme {
+	case "tcp", "rtu+tcp":
+		d.addr = u.Host
+		if u.Port() == "" {
+			d.addr = net.JoinHostPort(u.Hostname(), "502")
+		}
+	case "rtu":
+		//rtu:///dev/ttyUSB0 or rtu://COM3
+		d.addr = u.Host + u.Path
+		d.mode, err = parseMode(u.Query())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("scheme unsupported %q", u.Scheme)
+	}
+	if d.addr == "" {
+		return nil, fmt.Errorf("address missing in %q", raw)
+	}
+	return d, nil
+}
+
+// Defaults to 9600 8N1
+func parseMode(query url.Values) (*serial.Mode, error) {
+	mode := &serial.Mode{BaudRate: 9600, DataBits: 8}
+	var err error
+	if baud := query.Get("baud"); baud != "" {
+		mode.BaudRate, err = strconv.Atoi(baud)
+		if err != nil {
+			return nil, fmt.Errorf("baud invalid %q", baud)
+		}
+	}
+	if data := query.Get("data"); data != "" {
+		mode.DataBits, err = strconv.Atoi(data)
+		if err != nil {
+			return nil, fmt.Errorf("data bits invalid %q", data)
+		}
+	}
+	switch strings.ToUpper(query.Get("parity")) {
+	case "", "N":
+		mode.Parity = serial.NoParity
+	case "E":
+		mode.Parity = serial.EvenParity
+	case "O":
+		mode.Parity = serial.OddParity
+	default:
+		return nil, fmt.Errorf("parity invalid %q", query.Get("parity"))
+	}
+	switch query.Get("stop") {
+	case "", "1":
+		mode.StopBits = serial.OneStopBit
+	case "2":
+		mode.StopBits = serial.TwoStopBits
+	default:
+		return nil, fmt.Errorf("stop bits invalid %q", query.Get("stop"))
+	}
+	return mode, nil
+}
+
+func (d *device) protocol() modbus.Protocol {
+	if d.scheme == "tcp" {
+		return modbus.NewTcpProtocol()
+	}
+	return modbus.NewRtuProtocol()
+}
+
+func (d *device) openMaster(toms int) (modbus.CloseableMaster, error) {
+	var trans modbus.Transport
+	var err error
+	if d.scheme == "rtu" {
+		trans, err = openSerial(d.addr, d.mode)
+	} else {
+		trans, err = modbus.NewTcpTransport(d.addr, toms)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return modbus.NewMaster(d.protocol(), trans, toms), nil
+}
+
+func openSerial(name string, mode *serial.Mode) (modbus.Transport, error) {
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = port.SetReadTimeout(modbus.ReadToMs * time.Millisecond)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	sp := &serialPort{port: port}
+	return modbus.NewIoTransport(sp, sp), nil
+}
+
+// Implements: TimedReader, io.WriteCloser
+// Reports os.ErrClosed after close so reads stop
+type serialPort struct {
+	port   serial.Port
+	mutex  sync.Mutex
+	closed bool
+}
+
+func (p *serialPort) TimedRead(buf []byte) (int, error) {
+	n, err := p.port.Read(buf)
+	if err != nil && p.isClosed() {
+		return n, os.ErrClosed
+	}
+	return n, err
+}
+
+func (p *serialPort) Write(buf []byte) (int, error) {
+	return p.port.Write(buf)
+}
+
+func (p *serialPort) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+	return p.port.Close()
+}
+
+func (p *serialPort) isClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
@@ -93,6 +93,16 @@ func (c *Command) CheckResponse(buf []byte) error {
 	}
 }
 
+// Number of addresses accessed
+func (c *Command) Count() uint16 {
+	switch c.Code {
+	case WriteDo05, WriteWo06:
+		return 1
+	default:
+		return c.Corv
+	}
+}
+
 func (c *Command) RequestLength() uint16 {
 	switch c.Code {
 	case WriteDos15:
@@ -0,0 +1,532 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Framings
+const (
+	FramingRtu   = "rtu"
+	FramingAscii = "ascii"
+	FramingTcp   = "tcp"
+)
+
+// Largest ASCII frame: colon, 2 hex chars per byte and CRLF
+const maxAsciiFrame = 1 + 2*maxRtuFrame + 2
+
+type DecoderOptions struct {
+	Framing string
+	//RTU line speed for the t1.5 and t3.5 checks, 0 disables them
+	Baud int
+	//bits per character with start, parity and stop, default 11
+	CharBits int
+}
+
+// Request or response seen on the wire. Command holds the
+// request fields for requests and the decoded data for write
+// responses and for read responses paired with their request.
+// It is nil for exceptions and for bytes that do not parse.
+type Frame struct {
+	Time      time.Time //first byte, zero when unknown
+	Raw       []byte    //wire bytes including the framing
+	Pdu       []byte    //slave+code+data section
+	Tid       uint16    //tcp transaction id
+	Response  bool
+	Command   *Command
+	Exception byte     //exception code for exception responses
+	Request   *Frame   //paired request for responses
+	Issues    []string //CRC, LRC, timing and pairing violations
+}
+
+func (f *Frame) issue(format string, args ...interface{}) {
+	f.Issues = append(f.Issues, fmt.Sprintf(format, args...))
+}
+
+func (f *Frame) String() string {
+	sb := &strings.Builder{}
+	if !f.Time.IsZero() {
+		sb.WriteString(f.Time.Format("15:04:05.000000"))
+		sb.WriteString(" ")
+	}
+	switch {
+	case len(f.Pdu) < 2:
+		sb.WriteString("???")
+	case f.Response:
+		sb.WriteString("res")
+	default:
+		sb.WriteString("req")
+	}
+	if len(f.Pdu) >= 2 {
+		fmt.Fprintf(sb, " unit=%d func=%s", f.Pdu[0], CodeName(f.Pdu[1]))
+	}
+	if c := f.Command; c != nil {
+		fmt.Fprintf(sb, " addr=%04x corv=%04x", c.Address, c.Corv)
+		if len(c.Bools) > 0 {
+			fmt.Fprintf(sb, " bools=%v", c.Bools)
+		}
+		if len(c.Words) > 0 {
+			fmt.Fprintf(sb, " words=%s", HexWords(c.Words))
+		}
+	}
+	if f.Response && f.Exception != 0 {
+		fmt.Fprintf(sb, " ex=%02x", f.Exception)
+	}
+	if f.Request != nil && !f.Time.IsZero() && !f.Request.Time.IsZero() {
+		fmt.Fprintf(sb, " after=%s", f.Time.Sub(f.Request.Time))
+	}
+	fmt.Fprintf(sb, " frame=[%s]", HexBytes(f.Raw))
+	if len(f.Issues) > 0 {
+		fmt.Fprintf(sb, " issues=[%s]", strings.Join(f.Issues, "; "))
+	}
+	return sb.String()
+}
+
+type stamp struct {
+	offset int
+	time   time.Time
+}
+
+// Splits a captured byte stream into frames pairing each
+// response with the outstanding request of the same unit,
+// or of the same transaction id for TCP. Bytes are fed as
+// received, with the time of their first byte when known.
+// RTU frames are delimited by CRC and by silences of t3.5
+// when timing is enabled, otherwise Flush ends the stream.
+type Decoder struct {
+	framing string
+	char    time.Duration
+	t15     time.Duration
+	t35     time.Duration
+	buf     []byte
+	stamps  []stamp
+	lastEnd time.Time
+	pending map[uint16]*Frame
+}
+
+// Frames completed by data
+func (d *Decoder) Feed(t time.Time, data []byte) []*Frame {
+	frames := []*Frame{}
+	if d.timed() && !t.IsZero() && len(d.buf) > 0 {
+		end := d.endTime(len(d.buf))
+		if !end.IsZero() && t.Sub(end) >= d.t35 {
+			frames = append(frames, d.decode(true)...)
+		}
+	}
+	d.stamps = append(d.stamps, stamp{len(d.buf), t})
+	d.buf = append(d.buf, data...)
+	return append(frames, d.decode(false)...)
+}
+
+// Decodes buffered bytes as followed by silence
+func (d *Decoder) Flush() []*Frame {
+	return d.decode(true)
+}
+
+func (d *Decoder) timed() bool {
+	return d.framing == FramingRtu && d.char > 0
+}
+
+// Estimated from the stamp of the chunk holding the byte
+func (d *Decoder) byteTime(k int) time.Time {
+	s := stamp{}
+	for _, st := range d.stamps {
+		if st.offset > k {
+			break
+		}
+		s = st
+	}
+	if s.time.IsZero() {
+		return s.time
+	}
+	return s.time.Add(time.Duration(k-s.offset) * d.char)
+}
+
+// After the last of the first n bytes
+func (d *Decoder) endTime(n int) time.Time {
+	t := d.byteTime(n - 1)
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(d.char)
+}
+
+func (d *Decoder) decode(final bool) []*Frame {
+	frames := []*Frame{}
+	for len(d.buf) > 0 {
+		start, length := d.locate(d.buf, final)
+		switch {
+		case start > 0 && (length != 0 || final):
+			frames = append(frames, d.bad(start))
+		case start == 0 && length > 0:
+			frames = append(frames, d.good(length))
+		case final:
+			frames = append(frames, d.bad(len(d.buf)))
+		case len(d.buf) > 2*maxAsciiFrame:
+			frames = append(frames, d.bad(len(d.buf)-maxAsciiFrame))
+		default:
+			return frames
+		}
+	}
+	return frames
+}
+
+// Start and length of the next frame in buf. Length is -1
+// when the frame at start needs more bytes and 0 when no
+// frame was found, bytes before start are not frames.
+func (d *Decoder) locate(buf []byte, final bool) (start, length int) {
+	for start = 0; start < len(buf); start++ {
+		switch d.framing {
+		case FramingRtu:
+			length = d.rtuLength(buf[start:], final)
+			if length > 0 {
+				return
+			}
+			if length < 0 && start == 0 {
+				return
+			}
+		case FramingTcp:
+			length = tcpLength(buf[start:], final)
+			if length != 0 {
+				return
+			}
+		case FramingAscii:
+			if buf[start] != ':' {
+				continue
+			}
+			length = asciiLength(buf[start:], final)
+			if length != 0 {
+				return
+			}
+		}
+	}
+	return len(buf), 0
+}
+
+func (d *Decoder) good(n int) *Frame {
+	f := d.take(n)
+	switch d.framing {
+	case FramingRtu:
+		f.Pdu = f.Raw[:n-2]
+		d.classify(f, 0)
+	case FramingTcp:
+		f.Tid = encodeWord(f.Raw[0], f.Raw[1])
+		f.Pdu = f.Raw[6:]
+		d.classify(f, f.Tid)
+	case FramingAscii:
+		d.ascii(f)
+	}
+	return f
+}
+
+// RTU bytes ending in a frame of a known length and a bad
+// CRC are split and the frame decoded, anything else is
+// reported as unparsed
+func (d *Decoder) bad(n int) *Frame {
+	if d.framing == FramingRtu {
+		i := d.rtuTail(n)
+		if i == 0 {
+			f := d.take(n)
+			f.Pdu = f.Raw[:n-2]
+			f.issue("%s", ErrorMessage(rtuCheck(f.Raw)))
+			d.classify(f, 0)
+			return f
+		}
+		if i > 0 {
+			n = i
+		}
+	}
+	f := d.take(n)
+	f.issue("unparsed %d bytes", n)
+	return f
+}
+
+// Offset of the first n bytes tail with the size of a frame, -1 if none
+func (d *Decoder) rtuTail(n int) int {
+	for i := 0; i+5 <= n; i++ {
+		for _, size := range rtuSizes(d.buf[i:n], d.pending[0] != nil) {
+			if size == n-i {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Removes n bytes from the buffer checking RTU timing
+func (d *Decoder) take(n int) *Frame {
+	f := &Frame{}
+	f.Time = d.byteTime(0)
+	f.Raw = append([]byte(nil), d.buf[:n]...)
+	if d.timed() {
+		if !d.lastEnd.IsZero() && !f.Time.IsZero() {
+			silence := f.Time.Sub(d.lastEnd)
+			if silence < d.t35 {
+				f.issue("silence %s before frame below t3.5 %s", silence, d.t35)
+			}
+		}
+		for i := 1; i < len(d.stamps) && d.stamps[i].offset < n; i++ {
+			prev, next := d.stamps[i-1], d.stamps[i]
+			if prev.time.IsZero() || next.time.IsZero() {
+				continue
+			}
+			gap := next.time.Sub(prev.time.Add(time.Duration(next.offset-prev.offset) * d.char))
+			if gap > d.t15 {
+				f.issue("gap %s inside frame above t1.5 %s", gap, d.t15)
+			}
+		}
+	}
+	d.lastEnd = d.endTime(n)
+	d.consume(n)
+	return f
+}
+
+func (d *Decoder) consume(n int) {
+	if n >= len(d.buf) {
+		d.buf = nil
+		d.stamps = nil
+		return
+	}
+	t := d.byteTime(n)
+	stamps := []stamp{{0, t}}
+	for _, s := range d.stamps {
+		if s.offset > n {
+			stamps = append(stamps, stamp{s.offset - n, s.time})
+		}
+	}
+	d.buf = d.buf[n:]
+	d.stamps = stamps
+}
+
+// Length of the CRC valid frame at the start of buf,
+// 0 when none and -1 when more bytes may complete one.
+// Sizes expected for the outstanding request go first.
+// Unknown functions are framed by silence alone.
+func (d *Decoder) rtuLength(buf []byte, final bool) int {
+	if len(buf) < 3 {
+		if final {
+			return 0
+		}
+		return -1
+	}
+	more := false
+	for _, size := range rtuSizes(buf, d.pending[0] != nil) {
+		if size > len(buf) {
+			more = true
+			continue
+		}
+		if rtuCheck(buf[:size]) == nil {
+			return size
+		}
+	}
+	if final && len(buf) >= 4 && len(buf) <= maxRtuFrame && rtuCheck(buf) == nil {
+		return len(buf)
+	}
+	if more && !final {
+		return -1
+	}
+	return 0
+}
+
+// Candidate frame sizes including the CRC
+func rtuSizes(buf []byte, responseFirst bool) []int {
+	code := buf[1]
+	requests := []int{}
+	responses := []int{}
+	switch {
+	case code&0x80 != 0:
+		responses = append(responses, 5)
+	case code >= ReadDos01 && code <= ReadWis04:
+		requests = append(requests, 8)
+		responses = append(responses, 5+int(buf[2]))
+	case code == WriteDo05 || code == WriteWo06:
+		requests = append(requests, 8)
+	case code == WriteDos15 || code == WriteWos16:
+		if len(buf) > 6 {
+			requests = append(requests, 9+int(buf[6]))
+		} else {
+			requests = append(requests, 9)
+		}
+		responses = append(responses, 8)
+	}
+	if responseFirst {
+		return append(responses, requests...)
+	}
+	return append(requests, responses...)
+}
+
+func rtuCheck(frame []byte) error {
+	p := &rtuProtocol{}
+	return p.CheckWrapper(frame, uint16(len(frame)-2))
+}
+
+// MBAP header with protocol 0 and a length of up to 254
+func tcpLength(buf []byte, final bool) int {
+	if len(buf) < 6 {
+		if final {
+			return 0
+		}
+		return -1
+	}
+	proto := encodeWord(buf[2], buf[3])
+	length := int(encodeWord(buf[4], buf[5]))
+	if proto != 0 || length < 2 || length > maxRtuFrame-2 {
+		return 0
+	}
+	if len(buf) < 6+length {
+		if final {
+			return 0
+		}
+		return -1
+	}
+	return 6 + length
+}
+
+// Colon to line feed, a new colon restarts the frame
+func asciiLength(buf []byte, final bool) int {
+	for i := 1; i < len(buf) && i < maxAsciiFrame; i++ {
+		switch buf[i] {
+		case '\n':
+			return i + 1
+		case ':':
+			return 0
+		}
+	}
+	if final || len(buf) >= maxAsciiFrame {
+		return 0
+	}
+	return -1
+}
+
+func (d *Decoder) ascii(f *Frame) {
+	text := string(f.Raw[1:])
+	if !strings.HasSuffix(text, "\r\n") {
+		f.issue("CR missing before LF")
+	}
+	text = strings.TrimRight(text, "\r\n")
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data) < 3 {
+		f.issue("hex invalid %q", text)
+		return
+	}
+	f.Pdu = data[:len(data)-1]
+	_lrc := data[len(data)-1]
+	lrc := lrc8(f.Pdu)
+	if _lrc != lrc {
+		f.issue("lrc mismatch got %02x expected %02x", _lrc, lrc)
+	}
+	d.classify(f, 0)
+}
+
+// Two's complement of the byte sum
+func lrc8(buf []byte) (lrc byte) {
+	for _, b := range buf {
+		lrc += b
+	}
+	return -lrc
+}
+
+// Whether a PDU has the length of a request and of a response
+func pduKinds(pdu []byte) (request, response bool) {
+	n := len(pdu)
+	if n < 3 {
+		return
+	}
+	code := pdu[1]
+	switch {
+	case code&0x80 != 0:
+		response = n == 3
+	case code >= ReadDos01 && code <= ReadWis04:
+		request = n == 6
+		response = n == 3+int(pdu[2])
+	case code == WriteDo05 || code == WriteWo06:
+		request = n == 6
+		response = n == 6
+	case code == WriteDos15 || code == WriteWos16:
+		request = n >= 7 && n == 7+int(pdu[6])
+		response = n == 6
+	}
+	return
+}
+
+// Echoes and other ambiguous lengths are responses
+// only when matching the outstanding request
+func (d *Decoder) classify(f *Frame, key uint16) {
+	pdu := f.Pdu
+	if len(pdu) < 2 {
+		f.issue("pdu too short")
+		return
+	}
+	pending := d.pending[key]
+	matches := pending != nil && pending.Command.Slave == pdu[0] && pending.Command.Code == pdu[1]&0x7F
+	request, response := pduKinds(pdu)
+	if request && response {
+		request, response = !matches, matches
+	}
+	switch {
+	case response:
+		d.response(f, pending, matches, key)
+	case request:
+		d.request(f, pending, key)
+	default:
+		f.issue("function %s with %d bytes unsupported", CodeName(pdu[1]), len(pdu))
+	}
+}
+
+// Broadcasts get no response and are not left pending
+func (d *Decoder) request(f *Frame, pending *Frame, key uint16) {
+	c := &Command{}
+	if err := c.DecodeRequest(f.Pdu); err != nil {
+		f.issue("%s", ErrorMessage(err))
+		return
+	}
+	if err := c.CheckValid(); err != nil {
+		f.issue("%s", ErrorMessage(err))
+	}
+	f.Command = c
+	if pending != nil {
+		f.issue("previous request %s to unit %d unanswered", CodeName(pending.Command.Code), pending.Command.Slave)
+	}
+	delete(d.pending, key)
+	if c.Slave != 0 || d.framing == FramingTcp {
+		d.pending[key] = f
+	}
+}
+
+func (d *Decoder) response(f *Frame, pending *Frame, matches bool, key uint16) {
+	f.Response = true
+	pdu := f.Pdu
+	if matches {
+		f.Request = pending
+		delete(d.pending, key)
+	} else {
+		f.issue("response without request")
+	}
+	if pdu[1]&0x80 != 0 {
+		f.Exception = pdu[2]
+		return
+	}
+	c := &Command{}
+	if matches {
+		req := pending.Command
+		if err := req.CheckResponse(pdu); err != nil {
+			f.issue("%s", ErrorMessage(err))
+			return
+		}
+		c.DecodeResponse(pdu, req.Count())
+		if req.Code <= ReadWis04 {
+			c.Address = req.Address
+			c.Corv = req.Corv
+		}
+		f.Command = c
+		return
+	}
+	//unpaired reads lack the count
+	switch pdu[1] {
+	case WriteDo05, WriteWo06, WriteDos15, WriteWos16:
+		c.DecodeResponse(pdu, 0)
+		f.Command = c
+	}
+}
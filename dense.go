@@ -0,0 +1,263 @@
+package modbus
+
+import "sync"
+
+// Implements: Model, AtomicModel, BoundedModel
+// Preallocated slices per slave and area.
+// Only declared ranges are readable and writable,
+// direct out of range reads return zeros and
+// direct out of range writes are ignored.
+type denseModel struct {
+	mutex  sync.RWMutex
+	slaves [256]*denseSlave
+}
+
+type denseSlave struct {
+	dos denseBools
+	dis denseBools
+	wos denseWords
+	wis denseWords
+}
+
+type denseBools struct {
+	base   int
+	values []bool
+}
+
+type denseWords struct {
+	base   int
+	values []uint16
+}
+
+// Declares or redeclares an area range zeroing its contents.
+// Areas are named after the read function codes 01 to 04.
+func (m *denseModel) Declare(slave byte, area byte, base uint16, size int) error {
+	if size < 0 || int(base)+size > 0x10000 {
+		return formatErr("size %d out of range at base %04x", size, base)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ds := m.slaves[slave]
+	if ds == nil {
+		ds = &denseSlave{}
+		m.slaves[slave] = ds
+	}
+	switch area {
+	case ReadDos01:
+		ds.dos = denseBools{int(base), make([]bool, size)}
+	case ReadDis02:
+		ds.dis = denseBools{int(base), make([]bool, size)}
+	case ReadWos03:
+		ds.wos = denseWords{int(base), make([]uint16, size)}
+	case ReadWis04:
+		ds.wis = denseWords{int(base), make([]uint16, size)}
+	default:
+		return formatErr("area unsupported %d", area)
+	}
+	return nil
+}
+
+func (m *denseModel) Contains(slave byte, area byte, address uint16, count uint16) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.contains(slave, area, address, count)
+}
+
+func (m *denseModel) ReadDis(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readBools(m.dis(slave), address, count)
+}
+
+func (m *denseModel) ReadDos(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readBools(m.dos(slave), address, count)
+}
+
+func (m *denseModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readWords(m.wis(slave), address, count)
+}
+
+func (m *denseModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readWords(m.wos(slave), address, count)
+}
+
+func (m *denseModel) WriteDis(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeBools(m.dis(slave), address, values)
+}
+
+func (m *denseModel) WriteDos(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeBools(m.dos(slave), address, values)
+}
+
+func (m *denseModel) WriteWis(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeWords(m.wis(slave), address, values)
+}
+
+func (m *denseModel) WriteWos(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeWords(m.wos(slave), address, values)
+}
+
+// Model passed to f must not be used after f returns
+func (m *denseModel) Atomic(f func(model Model)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f(&denseModelView{m})
+}
+
+func (m *denseModel) contains(slave byte, area byte, address uint16, count uint16) bool {
+	ds := m.slaves[slave]
+	if ds == nil {
+		return false
+	}
+	base, size := 0, 0
+	switch area {
+	case ReadDos01:
+		base, size = ds.dos.base, len(ds.dos.values)
+	case ReadDis02:
+		base, size = ds.dis.base, len(ds.dis.values)
+	case ReadWos03:
+		base, size = ds.wos.base, len(ds.wos.values)
+	case ReadWis04:
+		base, size = ds.wis.base, len(ds.wis.values)
+	default:
+		return false
+	}
+	a := int(address)
+	return a >= base && a+int(count) <= base+size
+}
+
+func (m *denseModel) dos(slave byte) *denseBools {
+	if ds := m.slaves[slave]; ds != nil {
+		return &ds.dos
+	}
+	return nil
+}
+
+func (m *denseModel) dis(slave byte) *denseBools {
+	if ds := m.slaves[slave]; ds != nil {
+		return &ds.dis
+	}
+	return nil
+}
+
+func (m *denseModel) wos(slave byte) *denseWords {
+	if ds := m.slaves[slave]; ds != nil {
+		return &ds.wos
+	}
+	return nil
+}
+
+func (m *denseModel) wis(slave byte) *denseWords {
+	if ds := m.slaves[slave]; ds != nil {
+		return &ds.wis
+	}
+	return nil
+}
+
+func (m *denseModel) readBools(db *denseBools, address uint16, count uint16) []bool {
+	values := make([]bool, count)
+	if db == nil {
+		return values
+	}
+	a := int(address) - db.base
+	for i := range values {
+		if a+i >= 0 && a+i < len(db.values) {
+			values[i] = db.values[a+i]
+		}
+	}
+	return values
+}
+
+func (m *denseModel) readWords(dw *denseWords, address uint16, count uint16) []uint16 {
+	values := make([]uint16, count)
+	if dw == nil {
+		return values
+	}
+	a := int(address) - dw.base
+	for i := range values {
+		if a+i >= 0 && a+i < len(dw.values) {
+			values[i] = dw.values[a+i]
+		}
+	}
+	return values
+}
+
+func (m *denseModel) writeBools(db *denseBools, address uint16, values []bool) {
+	if db == nil {
+		return
+	}
+	a := int(address) - db.base
+	for i, v := range values {
+		if a+i >= 0 && a+i < len(db.values) {
+			db.values[a+i] = v
+		}
+	}
+}
+
+func (m *denseModel) writeWords(dw *denseWords, address uint16, values []uint16) {
+	if dw == nil {
+		return
+	}
+	a := int(address) - dw.base
+	for i, v := range values {
+		if a+i >= 0 && a+i < len(dw.values) {
+			dw.values[a+i] = v
+		}
+	}
+}
+
+// Implements: Model, BoundedModel
+// Unlocked access for Atomic callbacks
+type denseModelView struct {
+	m *denseModel
+}
+
+func (v *denseModelView) Contains(slave byte, area byte, address uint16, count uint16) bool {
+	return v.m.contains(slave, area, address, count)
+}
+
+func (v *denseModelView) ReadDis(slave byte, address uint16, count uint16) []bool {
+	return v.m.readBools(v.m.dis(slave), address, count)
+}
+
+func (v *denseModelView) ReadDos(slave byte, address uint16, count uint16) []bool {
+	return v.m.readBools(v.m.dos(slave), address, count)
+}
+
+func (v *denseModelView) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	return v.m.readWords(v.m.wis(slave), address, count)
+}
+
+func (v *denseModelView) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	return v.m.readWords(v.m.wos(slave), address, count)
+}
+
+func (v *denseModelView) WriteDis(slave byte, address uint16, values ...bool) {
+	v.m.writeBools(v.m.dis(slave), address, values)
+}
+
+func (v *denseModelView) WriteDos(slave byte, address uint16, values ...bool) {
+	v.m.writeBools(v.m.dos(slave), address, values)
+}
+
+func (v *denseModelView) WriteWis(slave byte, address uint16, values ...uint16) {
+	v.m.writeWords(v.m.wis(slave), address, values)
+}
+
+func (v *denseModelView) WriteWos(slave byte, address uint16, values ...uint16) {
+	v.m.writeWords(v.m.wos(slave), address, values)
+}
@@ -1,10 +1,34 @@
 package modbus
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
+// Returned by executors to make the slave skip the response
+var ErrNoResponse = errors.New("no response")
+
+// Matches with errors.Is when no response byte arrived in time
+var ErrTimeout = errors.New("timeout")
+
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
 // Implements: Executor
 // Applies commands to a model
 type modelExecutor struct {
@@ -17,6 +41,14 @@ func (e *modelExecutor) Execute(ci *Command) (co *Command, err error) {
 	co.Code = ci.Code
 	co.Address = ci.Address
 	co.Corv = ci.Corv
+	area := AreaOf(ci.Code)
+	if bm, ok := e.model.(BoundedModel); ok && area != 0 {
+		if !bm.Contains(ci.Slave, area, ci.Address, ci.Count()) {
+			co = nil
+			err = &ModbusException{ExIllegalAddress02}
+			return
+		}
+	}
 	switch ci.Code {
 	case ReadDos01:
 		co.Bools = e.model.ReadDos(ci.Slave, ci.Address, ci.Corv)
@@ -26,19 +58,52 @@ func (e *modelExecutor) Execute(ci *Command) (co *Command, err error) {
 		co.Words = e.model.ReadWos(ci.Slave, ci.Address, ci.Corv)
 	case ReadWis04:
 		co.Words = e.model.ReadWis(ci.Slave, ci.Address, ci.Corv)
+	case WriteDo05, WriteWo06, WriteDos15, WriteWos16:
+		write := func(model Model) { applyWrite(model, ci) }
+		if wc, ok := e.model.(WriteChecker); ok {
+			err = wc.CheckWrite(ci, write)
+			if err != nil {
+				co = nil
+				return
+			}
+		} else {
+			write(e.model)
+		}
+	default:
+		err = formatErr("unsupported code %d", ci.Code)
+		return
+	}
+	return
+}
+
+func applyWrite(model Model, ci *Command) {
+	switch ci.Code {
 	case WriteDo05:
-		e.model.WriteDos(ci.Slave, ci.Address, ci.Corv == TrueWord)
+		model.WriteDos(ci.Slave, ci.Address, ci.Corv == TrueWord)
 	case WriteWo06:
-		e.model.WriteWos(ci.Slave, ci.Address, ci.Corv)
+		model.WriteWos(ci.Slave, ci.Address, ci.Corv)
 	case WriteDos15:
-		e.model.WriteDos(ci.Slave, ci.Address, ci.Bools...)
+		model.WriteDos(ci.Slave, ci.Address, ci.Bools...)
 	case WriteWos16:
-		e.model.WriteWos(ci.Slave, ci.Address, ci.Words...)
+		model.WriteWos(ci.Slave, ci.Address, ci.Words...)
+	}
+}
+
+// Areas are named after the read function codes
+// 0 for unsupported codes
+func AreaOf(code byte) byte {
+	switch code {
+	case ReadDos01, WriteDo05, WriteDos15:
+		return ReadDos01
+	case ReadDis02:
+		return ReadDis02
+	case ReadWos03, WriteWo06, WriteWos16:
+		return ReadWos03
+	case ReadWis04:
+		return ReadWis04
 	default:
-		err = formatErr("unsupported code %d", ci.Code)
-		return
+		return 0
 	}
-	return
 }
 
 type ModbusException struct {
@@ -53,17 +118,44 @@ func (m *ModbusException) Error() string {
 // Applies commands to a transport
 type transportExecutor struct {
 	io.Closer
-	proto Protocol
-	trans Transport
-	toms  int
+	proto   Protocol
+	trans   Transport
+	toms    int
+	logger  Logger
+	metrics MetricsHook
 }
 
 func (e *transportExecutor) Close() error {
 	return e.trans.Close()
 }
 
+// Frames are logged here only, set a logger on the
+// transport itself to trace its raw bytes instead
+func (e *transportExecutor) SetLogger(logger Logger) {
+	e.logger = logger
+}
+
+func (e *transportExecutor) Logger() Logger {
+	return e.logger
+}
+
+// Applies to the underlying transport as well
+func (e *transportExecutor) SetMetrics(hook MetricsHook) {
+	e.metrics = hook
+	if mt, ok := e.trans.(Measurable); ok {
+		mt.SetMetrics(hook)
+	}
+}
+
 func (e *transportExecutor) Execute(ci *Command) (co *Command, err error) {
-	Trace("t>", ci)
+	logger := orDefaultLogger(e.logger)
+	logCommand(logger, "t", ">", ci)
+	if e.metrics != nil {
+		start := time.Now()
+		defer func() {
+			e.metrics.Command(SideMaster, ci, time.Since(start), err)
+		}()
+	}
 	err = ci.CheckValid()
 	if err != nil {
 		return
@@ -73,7 +165,7 @@ func (e *transportExecutor) Execute(ci *Command) (co *Command, err error) {
 	freq, req := e.proto.MakeBuffers(reqlen)
 	ci.EncodeRequest(req)
 	e.proto.WrapBuffer(freq, reqlen)
-	Trace("t>", freq)
+	logFrame(logger, "t", ">", freq, req, nil)
 	//report error to transport
 	//to discard on next interaction
 	defer func() {
@@ -97,7 +189,7 @@ func (e *transportExecutor) Execute(ci *Command) (co *Command, err error) {
 	reslen := ci.ResponseLength()
 	fres, res := e.proto.MakeBuffers(reslen)
 	_read, err := e.trans.TimedRead(fres, e.toms)
-	Trace("t<", fres[:_read])
+	logFrame(logger, "t", "<", fres[:_read], pduOf(fres, res, _read), err)
 	if _read == e.proto.ExceptionLen() { //6+3
 		err = e.proto.CheckWrapper(fres, 3)
 		if err != nil {
@@ -129,6 +221,6 @@ func (e *transportExecutor) Execute(ci *Command) (co *Command, err error) {
 	co = &Command{}
 	//not enough info in response packet to parse reads
 	co.DecodeResponse(res, ci.Corv)
-	Trace("t<", co)
+	logCommand(logger, "t", "<", co)
 	return
 }
@@ -0,0 +1,236 @@
+package modbus
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Fault int
+
+const (
+	FaultNone Fault = iota
+	FaultDelay
+	FaultDrop
+	FaultFlip
+	FaultSplit
+	FaultGarbage
+	FaultDuplicate
+	FaultClose
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultNone:
+		return "none"
+	case FaultDelay:
+		return "delay"
+	case FaultDrop:
+		return "drop"
+	case FaultFlip:
+		return "flip"
+	case FaultSplit:
+		return "split"
+	case FaultGarbage:
+		return "garbage"
+	case FaultDuplicate:
+		return "duplicate"
+	case FaultClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// Script faults apply in order to the frames read, one per frame.
+// Once exhausted each frame gets a random fault from Faults with
+// probability Rate. Faults defaults to all but close.
+// Split halves arrive ReadToMs apart, the silence transports
+// take as the end of a frame. Delay defaults to 50ms and
+// Garbage to 3 bytes.
+type FaultOptions struct {
+	Script  []Fault
+	Rate    float64
+	Faults  []Fault
+	Seed    int64
+	Delay   time.Duration
+	Garbage int
+}
+
+// Implements: Transport
+// Wraps a master transport where each TimedRead reads
+// a whole response frame or a slave transport where it
+// reads the request head. Delayed, split, garbage
+// and duplicated bytes left behind are delivered on the
+// following reads unless discarded.
+type faultTransport struct {
+	trans    Transport
+	opts     FaultOptions
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	script   []Fault
+	injected []Fault
+	pending  []byte
+	discard  bool
+	closed   bool
+}
+
+// Schedules faults for the next frames ahead of the script
+func (t *faultTransport) Inject(faults ...Fault) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.script = append(append([]Fault{}, faults...), t.script...)
+}
+
+// Faults other than none applied so far in order
+func (t *faultTransport) Injected() []Fault {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]Fault{}, t.injected...)
+}
+
+func (t *faultTransport) SetLogger(logger Logger) {
+	if lt, ok := t.trans.(Loggable); ok {
+		lt.SetLogger(logger)
+	}
+}
+
+func (t *faultTransport) Logger() Logger {
+	return loggerOf(t.trans)
+}
+
+func (t *faultTransport) Close() error {
+	return t.trans.Close()
+}
+
+func (t *faultTransport) Write(buf []byte) (int, error) {
+	if t.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	return t.trans.Write(buf)
+}
+
+func (t *faultTransport) DiscardOn() {
+	t.mutex.Lock()
+	t.discard = true
+	t.mutex.Unlock()
+	t.trans.DiscardOn()
+}
+
+func (t *faultTransport) DiscardIf() error {
+	t.mutex.Lock()
+	if t.discard {
+		t.discard = false
+		t.pending = nil
+	}
+	closed := t.closed
+	t.mutex.Unlock()
+	if closed {
+		return io.EOF
+	}
+	return t.trans.DiscardIf()
+}
+
+func (t *faultTransport) TimedRead(buf []byte, toms int) (count int, err error) {
+	if t.isClosed() {
+		return 0, io.EOF
+	}
+	t.mutex.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mutex.Unlock()
+	if len(pending) > 0 {
+		//stale bytes lead the stream
+		count = copy(buf, pending)
+		t.keep(pending[count:])
+		if count < len(buf) {
+			var c int
+			c, err = t.trans.TimedRead(buf[count:], toms)
+			count += c
+		}
+		return
+	}
+	frame := make([]byte, len(buf))
+	n, err := t.trans.TimedRead(frame, toms)
+	if n == 0 {
+		return
+	}
+	frame = frame[:n]
+	switch t.next() {
+	case FaultDelay:
+		if toms >= 0 && t.opts.Delay >= durationMs(toms) {
+			time.Sleep(durationMs(toms))
+			t.keep(frame)
+			return 0, &timeoutError{formatErr("read total timeout %d of %d", 0, len(buf))}
+		}
+		time.Sleep(t.opts.Delay)
+	case FaultDrop:
+		if toms > 0 {
+			time.Sleep(durationMs(toms))
+		}
+		return 0, &timeoutError{formatErr("read total timeout %d of %d", 0, len(buf))}
+	case FaultFlip:
+		t.mutex.Lock()
+		i := t.rand.Intn(len(frame))
+		frame[i] ^= 1 << uint(t.rand.Intn(8))
+		t.mutex.Unlock()
+	case FaultSplit:
+		//second half arrives after the inter frame timeout
+		half := (len(frame) + 1) / 2
+		count = copy(buf, frame[:half])
+		time.Sleep(durationMs(ReadToMs))
+		t.keep(frame[half:])
+		return count, formatErr("read inter timeout %d of %d", count, len(buf))
+	case FaultGarbage:
+		garbage := make([]byte, t.opts.Garbage)
+		t.mutex.Lock()
+		t.rand.Read(garbage)
+		t.mutex.Unlock()
+		frame = append(garbage, frame...)
+		count = copy(buf, frame)
+		t.keep(frame[count:])
+		return count, err
+	case FaultDuplicate:
+		t.keep(frame)
+	case FaultClose:
+		t.mutex.Lock()
+		t.closed = true
+		t.mutex.Unlock()
+		t.trans.Close()
+		count = copy(buf, frame[:len(frame)/2])
+		return count, io.EOF
+	}
+	count = copy(buf, frame)
+	return
+}
+
+func (t *faultTransport) next() (fault Fault) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.script) > 0 {
+		fault = t.script[0]
+		t.script = t.script[1:]
+	} else if t.opts.Rate > 0 && t.rand.Float64() < t.opts.Rate {
+		fault = t.opts.Faults[t.rand.Intn(len(t.opts.Faults))]
+	}
+	if fault != FaultNone {
+		t.injected = append(t.injected, fault)
+	}
+	return
+}
+
+func (t *faultTransport) keep(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pending = append(append([]byte{}, data...), t.pending...)
+}
+
+func (t *faultTransport) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closed
+}
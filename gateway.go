@@ -0,0 +1,48 @@
+package modbus
+
+import (
+	"errors"
+	"sync"
+)
+
+// Implements: Executor, CloseableExecutor
+// Forwards commands from any number of front end connections to
+// a single downstream executor one at a time keeping the unit id.
+// Downstream exceptions pass through, timeouts are answered with
+// exception 0B and other downstream errors with exception 0A.
+// Unit 0 is a broadcast downstream and is never answered.
+type gatewayExecutor struct {
+	mutex sync.Mutex
+	exec  Executor
+}
+
+func (e *gatewayExecutor) Execute(ci *Command) (co *Command, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	co, err = e.exec.Execute(ci)
+	if ci.Slave == 0 {
+		return nil, ErrNoResponse
+	}
+	if err == nil {
+		return
+	}
+	var me *ModbusException
+	switch {
+	case errors.As(err, &me):
+	case errors.Is(err, ErrTimeout):
+		err = &ModbusException{ExGatewayTarget0B}
+	default:
+		err = &ModbusException{ExGatewayPath0A}
+	}
+	return
+}
+
+// Closes the downstream executor when closeable
+func (e *gatewayExecutor) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if ce, ok := e.exec.(CloseableExecutor); ok {
+		return ce.Close()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package modbus
+
+import "sync"
+
+// Implements: Model, AtomicModel
+// Serializes access to a model not safe for concurrent use
+type lockedModel struct {
+	mutex sync.RWMutex
+	model Model
+}
+
+func (m *lockedModel) ReadDis(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.model.ReadDis(slave, address, count)
+}
+
+func (m *lockedModel) ReadDos(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.model.ReadDos(slave, address, count)
+}
+
+func (m *lockedModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.model.ReadWis(slave, address, count)
+}
+
+func (m *lockedModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.model.ReadWos(slave, address, count)
+}
+
+func (m *lockedModel) WriteDis(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.model.WriteDis(slave, address, values...)
+}
+
+func (m *lockedModel) WriteDos(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.model.WriteDos(slave, address, values...)
+}
+
+func (m *lockedModel) WriteWis(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.model.WriteWis(slave, address, values...)
+}
+
+func (m *lockedModel) WriteWos(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.model.WriteWos(slave, address, values...)
+}
+
+func (m *lockedModel) Atomic(f func(model Model)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f(m.model)
+}
+
+// Runs f atomically when the model supports it or
+// directly otherwise
+func RunAtomic(model Model, f func(model Model)) {
+	if am, ok := model.(AtomicModel); ok {
+		am.Atomic(f)
+		return
+	}
+	f(model)
+}
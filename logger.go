@@ -0,0 +1,244 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Receives trace entries from masters, slaves and transports.
+// Must be safe to call from the goroutine serving the connection.
+type Logger interface {
+	Log(entry *LogEntry)
+}
+
+// Implemented by masters, executors and transports
+// that accept a per instance logger.
+// Logger returns nil when none was set.
+type Loggable interface {
+	SetLogger(logger Logger)
+	Logger() Logger
+}
+
+// Sources
+// t: transport executor (master side)
+// e: applied executor (slave side)
+// io: raw transport bytes
+// m: logging middleware
+// a: access policy audit
+type LogEntry struct {
+	Time    time.Time
+	Source  string
+	Dir     string //> outgoing, < incoming
+	Frame   []byte //full frame including protocol wrapper
+	Pdu     []byte //unwrapped slave+code+data section
+	Command *Command
+	Err     error
+}
+
+func (e *LogEntry) Slave() (byte, bool) {
+	if e.Command != nil {
+		return e.Command.Slave, true
+	}
+	if len(e.Pdu) > 0 {
+		return e.Pdu[0], true
+	}
+	return 0, false
+}
+
+func (e *LogEntry) Code() (byte, bool) {
+	if e.Command != nil {
+		return e.Command.Code, true
+	}
+	if len(e.Pdu) > 1 {
+		return e.Pdu[1], true
+	}
+	return 0, false
+}
+
+func (e *LogEntry) String() string {
+	sb := &strings.Builder{}
+	sb.WriteString(e.Time.Format("15:04:05.000000"))
+	sb.WriteString(" ")
+	sb.WriteString(e.Source)
+	sb.WriteString(e.Dir)
+	if slave, ok := e.Slave(); ok {
+		fmt.Fprintf(sb, " unit=%d", slave)
+	}
+	if code, ok := e.Code(); ok {
+		fmt.Fprintf(sb, " func=%s", CodeName(code))
+	}
+	if e.Command != nil {
+		c := e.Command
+		fmt.Fprintf(sb, " addr=%04x corv=%04x", c.Address, c.Corv)
+		if len(c.Bools) > 0 {
+			fmt.Fprintf(sb, " bools=%v", c.Bools)
+		}
+		if len(c.Words) > 0 {
+			fmt.Fprintf(sb, " words=%s", HexWords(c.Words))
+		}
+	}
+	if e.Frame != nil {
+		fmt.Fprintf(sb, " frame=[%s]", HexBytes(e.Frame))
+	}
+	if e.Err != nil {
+		fmt.Fprintf(sb, " err=%s", firstLine(e.Err.Error()))
+	}
+	return sb.String()
+}
+
+// Returns a name like ReadWos03 or the hex code when unknown.
+// Exception codes are reported with the Ex suffix.
+func CodeName(code byte) string {
+	suffix := ""
+	if code&0x80 != 0 {
+		code &= 0x7F
+		suffix = "Ex"
+	}
+	name := ""
+	switch code {
+	case ReadDos01:
+		name = "ReadDos01"
+	case ReadDis02:
+		name = "ReadDis02"
+	case ReadWos03:
+		name = "ReadWos03"
+	case ReadWis04:
+		name = "ReadWis04"
+	case WriteDo05:
+		name = "WriteDo05"
+	case WriteWo06:
+		name = "WriteWo06"
+	case WriteDos15:
+		name = "WriteDos15"
+	case WriteWos16:
+		name = "WriteWos16"
+	default:
+		name = fmt.Sprintf("%02x", code)
+	}
+	return name + suffix
+}
+
+// Formats as space separated hex pairs: 01 03 00 00
+func HexBytes(buf []byte) string {
+	sb := &strings.Builder{}
+	for i, b := range buf {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(sb, "%02x", b)
+	}
+	return sb.String()
+}
+
+// Formats as space separated hex words: 0001 ffff
+func HexWords(words []uint16) string {
+	sb := &strings.Builder{}
+	for i, w := range words {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(sb, "%04x", w)
+	}
+	return sb.String()
+}
+
+// Implements: Logger
+// Writes entry strings to a log.Logger
+type stdLogger struct {
+	log *log.Logger
+}
+
+func (l *stdLogger) Log(entry *LogEntry) {
+	l.log.Println(entry.String())
+}
+
+// Implements: Logger
+// Writes to the standard log package when trace is enabled
+type traceLogger struct {
+}
+
+func (l *traceLogger) Log(entry *LogEntry) {
+	if traceEnabled {
+		log.Println(entry.String())
+	}
+}
+
+// Implements: Logger
+// Discards all entries
+type nopLogger struct {
+}
+
+func (l *nopLogger) Log(entry *LogEntry) {
+}
+
+// Implements: Logger
+// Forwards to a plain function
+type funcLogger func(entry *LogEntry)
+
+func (l funcLogger) Log(entry *LogEntry) {
+	l(entry)
+}
+
+var defaultLogger Logger = &traceLogger{}
+
+func orDefaultLogger(logger Logger) Logger {
+	if logger != nil {
+		return logger
+	}
+	return defaultLogger
+}
+
+// nil if target is not loggable
+func loggerOf(target interface{}) Logger {
+	if lt, ok := target.(Loggable); ok {
+		return lt.Logger()
+	}
+	return nil
+}
+
+func logCommand(logger Logger, source string, dir string, c *Command) {
+	entry := &LogEntry{Time: time.Now(), Source: source, Dir: dir, Command: c}
+	logger.Log(entry)
+}
+
+// Copies the bytes since callers reuse their buffers
+// and loggers may keep entries or process them later
+func logFrame(logger Logger, source string, dir string, frame []byte, pdu []byte, err error) {
+	entry := &LogEntry{Time: time.Now(), Source: source, Dir: dir, Err: err}
+	if frame != nil {
+		entry.Frame = append([]byte{}, frame...)
+	}
+	if pdu != nil {
+		entry.Pdu = append([]byte{}, pdu...)
+	}
+	logger.Log(entry)
+}
+
+// pdu is a subslice of frame as returned by MakeBuffers
+// returns the pdu part covered by the first count frame bytes
+func pduOf(frame []byte, pdu []byte, count int) []byte {
+	offset := cap(frame) - cap(pdu)
+	n := count - offset
+	if n < 0 {
+		n = 0
+	}
+	if n > len(pdu) {
+		n = len(pdu)
+	}
+	return pdu[:n]
+}
+
+// strips the stack appended by formatErr
+func firstLine(msg string) string {
+	i := strings.IndexByte(msg, '\n')
+	if i >= 0 {
+		msg = msg[:i]
+	}
+	i = strings.Index(msg, " goroutine ")
+	if i >= 0 {
+		msg = msg[:i]
+	}
+	return msg
+}
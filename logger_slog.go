@@ -0,0 +1,49 @@
+//go:build go1.21
+
+package modbus
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logs entries at debug level with hex frames and decoded unit and function
+func NewSlogLogger(log *slog.Logger) Logger {
+	return &slogLogger{log}
+}
+
+// Implements: Logger
+// Adapts entries to structured slog records
+type slogLogger struct {
+	log *slog.Logger
+}
+
+func (l *slogLogger) Log(entry *LogEntry) {
+	ctx := context.Background()
+	if !l.log.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, 8)
+	attrs = append(attrs, slog.String("source", entry.Source))
+	attrs = append(attrs, slog.String("dir", entry.Dir))
+	if slave, ok := entry.Slave(); ok {
+		attrs = append(attrs, slog.Int("unit", int(slave)))
+	}
+	if code, ok := entry.Code(); ok {
+		attrs = append(attrs, slog.String("func", CodeName(code)))
+	}
+	if entry.Command != nil {
+		c := entry.Command
+		attrs = append(attrs, slog.Int("addr", int(c.Address)))
+		attrs = append(attrs, slog.Int("corv", int(c.Corv)))
+	}
+	if entry.Frame != nil {
+		attrs = append(attrs, slog.String("frame", HexBytes(entry.Frame)))
+	}
+	if entry.Err != nil {
+		attrs = append(attrs, slog.String("err", firstLine(entry.Err.Error())))
+	}
+	record := slog.NewRecord(entry.Time, slog.LevelDebug, "modbus", 0)
+	record.AddAttrs(attrs...)
+	l.log.Handler().Handle(ctx, record)
+}
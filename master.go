@@ -14,6 +14,24 @@ func (m *closableMaster) Close() error {
 	return nil
 }
 
+// Applies to the executor when loggable
+func (m *closableMaster) SetLogger(logger Logger) {
+	if lt, ok := m.exec.(Loggable); ok {
+		lt.SetLogger(logger)
+	}
+}
+
+func (m *closableMaster) Logger() Logger {
+	return loggerOf(m.exec)
+}
+
+// Applies to the executor when measurable
+func (m *closableMaster) SetMetrics(hook MetricsHook) {
+	if mt, ok := m.exec.(Measurable); ok {
+		mt.SetMetrics(hook)
+	}
+}
+
 func (m *closableMaster) Execute(c *Command) (*Command, error) {
 	return m.exec.Execute(c)
 }
@@ -0,0 +1,293 @@
+package modbus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sides
+const (
+	SideMaster = "master"
+	SideSlave  = "slave"
+)
+
+// Latency histogram upper bounds in seconds
+var MetricsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Receives instrumentation events
+type MetricsHook interface {
+	//completed command on the master or slave side
+	Command(side string, ci *Command, elapsed time.Duration, err error)
+	//malformed request in the slave loop
+	Framing(err error)
+	//bytes read < or written >
+	Bytes(dir string, count int)
+}
+
+type Measurable interface {
+	SetMetrics(hook MetricsHook)
+}
+
+// Classes: exception, timeout, framing, io and protocol
+// for anything else like CRC or echo mismatches.
+// Empty for nil errors and skipped responses.
+func ErrorClass(err error) string {
+	var me *ModbusException
+	switch {
+	case err == nil, errors.Is(err, ErrNoResponse):
+		return ""
+	case errors.As(err, &me):
+		return "exception"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrFraming):
+		return "framing"
+	case isIoErr(err):
+		return "io"
+	default:
+		return "protocol"
+	}
+}
+
+type commandKey struct {
+	side  string
+	slave byte
+	code  byte
+}
+
+type commandStats struct {
+	requests   uint64
+	errors     map[string]uint64
+	exceptions map[byte]uint64
+	buckets    []uint64
+	sum        float64
+}
+
+// Implements: MetricsHook
+// Counters for a single named device
+type Metrics struct {
+	device     string
+	mutex      sync.Mutex
+	commands   map[commandKey]*commandStats
+	bytesIn    uint64
+	bytesOut   uint64
+	framing    uint64
+	reconnects uint64
+}
+
+func (m *Metrics) Command(side string, ci *Command, elapsed time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := commandKey{side, ci.Slave, ci.Code}
+	cs, ok := m.commands[key]
+	if !ok {
+		cs = &commandStats{}
+		cs.errors = make(map[string]uint64)
+		cs.exceptions = make(map[byte]uint64)
+		cs.buckets = make([]uint64, len(MetricsBuckets))
+		m.commands[key] = cs
+	}
+	cs.requests++
+	if class := ErrorClass(err); class != "" {
+		cs.errors[class]++
+	}
+	var me *ModbusException
+	if errors.As(err, &me) {
+		cs.exceptions[me.Code]++
+	}
+	seconds := elapsed.Seconds()
+	cs.sum += seconds
+	for i, le := range MetricsBuckets {
+		if seconds <= le {
+			cs.buckets[i]++
+		}
+	}
+}
+
+func (m *Metrics) Framing(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.framing++
+}
+
+func (m *Metrics) Bytes(dir string, count int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if dir == "<" {
+		m.bytesIn += uint64(count)
+	} else {
+		m.bytesOut += uint64(count)
+	}
+}
+
+// To be called by code reopening the device transport
+func (m *Metrics) Reconnect() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reconnects++
+}
+
+// Implements: http.Handler
+// Exports its devices in the Prometheus text format
+type MetricsRegistry struct {
+	mutex   sync.Mutex
+	devices map[string]*Metrics
+}
+
+// Creates the device metrics on first use
+func (r *MetricsRegistry) Device(name string) *Metrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m, ok := r.devices[name]
+	if !ok {
+		m = &Metrics{}
+		m.device = name
+		m.commands = make(map[commandKey]*commandStats)
+		r.devices[name] = m
+	}
+	return m
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Series are sorted by device, side, unit and function
+func (r *MetricsRegistry) Write(writer io.Writer) error {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.devices))
+	for name := range r.devices {
+		names = append(names, name)
+	}
+	devices := r.devices
+	r.mutex.Unlock()
+	sort.Strings(names)
+	w := bufio.NewWriter(writer)
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	each := func(f func(m *Metrics, keys []commandKey)) {
+		for _, name := range names {
+			m := devices[name]
+			m.mutex.Lock()
+			f(m, m.sortedKeys())
+			m.mutex.Unlock()
+		}
+	}
+	header("modbus_requests_total", "counter", "Commands completed.")
+	each(func(m *Metrics, keys []commandKey) {
+		for _, k := range keys {
+			fmt.Fprintf(w, "modbus_requests_total{%s} %d\n", m.labels(k), m.commands[k].requests)
+		}
+	})
+	header("modbus_errors_total", "counter", "Commands failed by error class.")
+	each(func(m *Metrics, keys []commandKey) {
+		for _, k := range keys {
+			cs := m.commands[k]
+			classes := make([]string, 0, len(cs.errors))
+			for class := range cs.errors {
+				classes = append(classes, class)
+			}
+			sort.Strings(classes)
+			for _, class := range classes {
+				fmt.Fprintf(w, "modbus_errors_total{%s,class=%q} %d\n", m.labels(k), class, cs.errors[class])
+			}
+		}
+	})
+	header("modbus_framing_errors_total", "counter", "Malformed requests in the slave loop.")
+	each(func(m *Metrics, keys []commandKey) {
+		fmt.Fprintf(w, "modbus_framing_errors_total{device=%s} %d\n", quoteLabel(m.device), m.framing)
+	})
+	header("modbus_exceptions_total", "counter", "Exception responses by code.")
+	each(func(m *Metrics, keys []commandKey) {
+		for _, k := range keys {
+			cs := m.commands[k]
+			codes := make([]int, 0, len(cs.exceptions))
+			for code := range cs.exceptions {
+				codes = append(codes, int(code))
+			}
+			sort.Ints(codes)
+			for _, code := range codes {
+				fmt.Fprintf(w, "modbus_exceptions_total{%s,code=\"%02x\"} %d\n", m.labels(k), code, cs.exceptions[byte(code)])
+			}
+		}
+	})
+	header("modbus_request_duration_seconds", "histogram", "Command latency.")
+	each(func(m *Metrics, keys []commandKey) {
+		for _, k := range keys {
+			cs := m.commands[k]
+			labels := m.labels(k)
+			for i, le := range MetricsBuckets {
+				fmt.Fprintf(w, "modbus_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, formatFloat(le), cs.buckets[i])
+			}
+			fmt.Fprintf(w, "modbus_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, cs.requests)
+			fmt.Fprintf(w, "modbus_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(cs.sum))
+			fmt.Fprintf(w, "modbus_request_duration_seconds_count{%s} %d\n", labels, cs.requests)
+		}
+	})
+	header("modbus_bytes_total", "counter", "Transport bytes by direction.")
+	each(func(m *Metrics, keys []commandKey) {
+		fmt.Fprintf(w, "modbus_bytes_total{device=%s,direction=\"in\"} %d\n", quoteLabel(m.device), m.bytesIn)
+		fmt.Fprintf(w, "modbus_bytes_total{device=%s,direction=\"out\"} %d\n", quoteLabel(m.device), m.bytesOut)
+	})
+	header("modbus_reconnects_total", "counter", "Transport reconnections.")
+	each(func(m *Metrics, keys []commandKey) {
+		fmt.Fprintf(w, "modbus_reconnects_total{device=%s} %d\n", quoteLabel(m.device), m.reconnects)
+	})
+	return w.Flush()
+}
+
+func (m *Metrics) sortedKeys() []commandKey {
+	keys := make([]commandKey, 0, len(m.commands))
+	for k := range m.commands {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.slave != b.slave {
+			return a.slave < b.slave
+		}
+		return a.code < b.code
+	})
+	return keys
+}
+
+func (m *Metrics) labels(k commandKey) string {
+	return fmt.Sprintf("device=%s,side=%q,unit=\"%d\",function=%q", quoteLabel(m.device), k.side, k.slave, CodeName(k.code))
+}
+
+// Prometheus escapes only backslash, quote and newline
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Reports every command passing through as side
+func MetricsMiddleware(hook MetricsHook, side string) Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (*Command, error) {
+			start := time.Now()
+			co, err := next.Execute(ci)
+			hook.Command(side, ci, time.Since(start), err)
+			return co, err
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package modbus
+
+import (
+	"time"
+)
+
+// Wraps an executor adding behavior before and after next
+type Middleware func(next Executor) Executor
+
+// Implements: Executor
+type ExecutorFunc func(ci *Command) (*Command, error)
+
+func (f ExecutorFunc) Execute(ci *Command) (*Command, error) {
+	return f(ci)
+}
+
+// First middleware is the outermost. Works the same around
+// a transport executor on the master side or a model
+// executor on the slave side.
+func Chain(exec Executor, mws ...Middleware) Executor {
+	for i := len(mws) - 1; i >= 0; i-- {
+		exec = mws[i](exec)
+	}
+	return exec
+}
+
+// Logs commands in and out with source m
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (*Command, error) {
+			logCommand(logger, "m", ">", ci)
+			co, err := next.Execute(ci)
+			if err != nil {
+				logFrame(logger, "m", "<", nil, nil, err)
+				return co, err
+			}
+			logCommand(logger, "m", "<", co)
+			return co, err
+		})
+	}
+}
+
+// Reports the elapsed time of every command
+func TimingMiddleware(observe func(ci *Command, elapsed time.Duration, err error)) Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (*Command, error) {
+			start := time.Now()
+			co, err := next.Execute(ci)
+			observe(ci, time.Since(start), err)
+			return co, err
+		})
+	}
+}
+
+// Turns panics into exception 04 and reports them when
+// report is not nil
+func RecoverMiddleware(report func(ci *Command, value interface{})) Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (co *Command, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					if report != nil {
+						report(ci, r)
+					}
+					co = nil
+					err = &ModbusException{ExDeviceFailure04}
+				}
+			}()
+			return next.Execute(ci)
+		})
+	}
+}
+
+// Other function codes fail with exception 01
+func AllowMiddleware(codes ...byte) Middleware {
+	allowed := make(map[byte]bool)
+	for _, code := range codes {
+		allowed[code] = true
+	}
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (*Command, error) {
+			if !allowed[ci.Code] {
+				return nil, &ModbusException{ExIllegalFunction01}
+			}
+			return next.Execute(ci)
+		})
+	}
+}
+
+// Rejects invalid requests with the slave exceptions:
+// 01 unsupported code, 03 invalid count or value and
+// 02 address range past the end of the area
+func ValidateMiddleware() Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ci *Command) (*Command, error) {
+			err := validationErr(ci)
+			if err != nil {
+				return nil, err
+			}
+			if int(ci.Address)+int(ci.Count()) > 0x10000 {
+				return nil, &ModbusException{ExIllegalAddress02}
+			}
+			return next.Execute(ci)
+		})
+	}
+}
+
+// Maps CheckValid failures to exceptions
+func validationErr(ci *Command) error {
+	err := ci.CheckValid()
+	if err == nil {
+		return nil
+	}
+	if AreaOf(ci.Code) == 0 {
+		return &ModbusException{ExIllegalFunction01}
+	}
+	return &ModbusException{ExIllegalValue03}
+}
@@ -1,15 +1,32 @@
 package modbus
 
 import (
+	"encoding/json"
 	"io"
+	"log"
+	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
+// Controls the default logger used when none was set
 func EnableTrace(enable bool) {
 	traceEnabled = enable
 }
 
+func NewStdLogger(log *log.Logger) Logger {
+	return &stdLogger{log}
+}
+
+func NewFuncLogger(f func(entry *LogEntry)) Logger {
+	return funcLogger(f)
+}
+
+func NewNopLogger() Logger {
+	return &nopLogger{}
+}
+
 func NewNopProtocol() Protocol {
 	return &nopProtocol{}
 }
@@ -22,6 +39,65 @@ func NewRtuProtocol() Protocol {
 	return &rtuProtocol{}
 }
 
+// RTU slave protocol for shared buses answering only units
+func NewRtuMultidropProtocol(units ...byte) Protocol {
+	p := &multidropProtocol{}
+	for _, unit := range units {
+		p.units[unit] = true
+	}
+	return p
+}
+
+// Timing checks apply to RTU with Baud set. Above 19200 baud
+// t1.5 and t3.5 are fixed to 750us and 1750us by the spec.
+func NewDecoder(opts DecoderOptions) (*Decoder, error) {
+	switch opts.Framing {
+	case FramingRtu, FramingAscii, FramingTcp:
+	default:
+		return nil, formatErr("framing unsupported %q", opts.Framing)
+	}
+	if opts.CharBits <= 0 {
+		opts.CharBits = 11
+	}
+	d := &Decoder{}
+	d.framing = opts.Framing
+	d.pending = make(map[uint16]*Frame)
+	if opts.Baud > 0 {
+		d.char = time.Duration(opts.CharBits) * time.Second / time.Duration(opts.Baud)
+		d.t15 = d.char * 3 / 2
+		d.t35 = d.char * 7 / 2
+		if opts.Baud > 19200 {
+			d.t15 = 750 * time.Microsecond
+			d.t35 = 1750 * time.Microsecond
+		}
+	}
+	return d, nil
+}
+
+// Add masters then serve it with net/http
+func NewBridge() *Bridge {
+	b := &Bridge{}
+	b.devices = make(map[string]*bridgeDevice)
+	b.locks = make(map[Master]*sync.Mutex)
+	return b
+}
+
+// Export it with net/http as any other handler
+func NewMetricsRegistry() *MetricsRegistry {
+	r := &MetricsRegistry{}
+	r.devices = make(map[string]*Metrics)
+	return r
+}
+
+func NewSlave(proto Protocol, trans Transport, exec Executor, opts SlaveOptions) *Slave {
+	s := &Slave{}
+	s.proto = proto
+	s.trans = trans
+	s.exec = exec
+	s.opts = opts
+	return s
+}
+
 func NewMaster(proto Protocol, trans Transport, toms int) CloseableMaster {
 	exec := NewTransportExecutor(proto, trans, toms)
 	return NewCloseableMaster(exec, trans)
@@ -54,6 +130,25 @@ func NewConnTransport(conn net.Conn) Transport {
 	return NewIoTransport(reader, conn)
 }
 
+// Decorates trans to inject faults in the frames read
+func NewFaultTransport(trans Transport, opts FaultOptions) *faultTransport {
+	if len(opts.Faults) == 0 {
+		opts.Faults = []Fault{FaultDelay, FaultDrop, FaultFlip, FaultSplit, FaultGarbage, FaultDuplicate}
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = 50 * time.Millisecond
+	}
+	if opts.Garbage <= 0 {
+		opts.Garbage = 3
+	}
+	t := &faultTransport{}
+	t.trans = trans
+	t.opts = opts
+	t.rand = rand.New(rand.NewSource(opts.Seed))
+	t.script = append([]Fault{}, opts.Script...)
+	return t
+}
+
 func NewIoTransport(reader TimedReader, writerCloser io.WriteCloser) Transport {
 	trans := &ioTransport{}
 	trans.reader = reader
@@ -62,10 +157,58 @@ func NewIoTransport(reader TimedReader, writerCloser io.WriteCloser) Transport {
 	return trans
 }
 
+// Records Modbus/TCP traffic as Ethernet frames on port 502.
+// Set master when trans is the master side of the connection.
+func NewPcapTcpTransport(trans Transport, writer io.Writer, master bool) (Transport, error) {
+	return newPcapTransport(trans, writer, PcapLinkEthernet, master)
+}
+
+// Records raw RTU frames under DLT_USER0
+func NewPcapRtuTransport(trans Transport, writer io.Writer) (Transport, error) {
+	return newPcapTransport(trans, writer, PcapLinkRtu, false)
+}
+
+func newPcapTransport(trans Transport, writer io.Writer, link uint16, master bool) (Transport, error) {
+	err := writePcapHeader(writer, link)
+	if err != nil {
+		return nil, err
+	}
+	pt := &pcapTransport{}
+	pt.trans = trans
+	pt.writer = writer
+	pt.link = link
+	pt.master = master
+	return pt, nil
+}
+
+// Follows each write with the matching read of the written range
+func NewVerifyMaster(master CloseableMaster, opts VerifyOptions) CloseableMaster {
+	vm := &verifyMaster{}
+	vm.CloseableMaster = master
+	vm.opts = opts
+	return vm
+}
+
+// Factory creates a protocol per connection
+func NewServer(factory func() Protocol, exec Executor, opts ServerOptions) *Server {
+	server := &Server{}
+	server.factory = factory
+	server.exec = exec
+	server.opts = opts
+	server.conns = make(map[*serverConn]bool)
+	return server
+}
+
+func NewTcpServer(exec Executor, opts ServerOptions) *Server {
+	return NewServer(NewTcpProtocol, exec, opts)
+}
+
 func NewCloseableMaster(exec Executor, closer io.Closer) CloseableMaster {
 	master := &closableMaster{}
 	master.exec = exec
-	master.closer = closer.Close
+	if closer != nil {
+		master.closer = closer.Close
+	}
 	return master
 }
 
@@ -77,12 +220,153 @@ func NewTransportExecutor(proto Protocol, trans Transport, toms int) CloseableEx
 	return exec
 }
 
+// Appends a JSON line per command to writer
+func NewRecordExecutor(exec Executor, writer io.Writer) Executor {
+	rec := &recordExecutor{}
+	rec.exec = exec
+	rec.encoder = json.NewEncoder(writer)
+	return rec
+}
+
+// Appends a JSON line per master transaction to writer
+// with framing FramingRtu or FramingTcp
+func NewRecordTransport(trans Transport, writer io.Writer, framing string) (Transport, error) {
+	switch framing {
+	case FramingRtu, FramingTcp:
+	default:
+		return nil, formatErr("framing unsupported %q", framing)
+	}
+	t := &recordTransport{}
+	t.trans = trans
+	t.framing = framing
+	t.encoder = json.NewEncoder(writer)
+	return t, nil
+}
+
+// Timed replays sleep the recorded elapsed time before answering
+func NewReplayExecutor(txs []*Transaction, timed bool) Executor {
+	exec := &replayExecutor{}
+	exec.txs = txs
+	exec.timed = timed
+	return exec
+}
+
+func NewScanner(master Master, opts ScanOptions) *Scanner {
+	scanner := &Scanner{}
+	scanner.master = master
+	scanner.opts = opts
+	return scanner
+}
+
+// Unrouted units answer exception 0B (gateway target failed to respond)
+func NewTcpRouter() *Router {
+	router := &Router{}
+	router.routes = make(map[byte]Executor)
+	return router
+}
+
+// Unrouted units get no response
+func NewRtuRouter() *Router {
+	router := NewTcpRouter()
+	router.silent = true
+	return router
+}
+
+// Client may be nil for local executors, see ClientOf
+func NewAccessExecutor(exec Executor, policy *AccessPolicy, client *Client) Executor {
+	ae := &accessExecutor{}
+	ae.exec = exec
+	ae.policy = policy
+	ae.client = client
+	return ae
+}
+
+// Serializes access to a downstream executor mapping failures
+// to gateway exceptions, serve it with NewTcpServer or RunSlave
+func NewGatewayExecutor(exec Executor) CloseableExecutor {
+	gw := &gatewayExecutor{}
+	gw.exec = exec
+	return gw
+}
+
+// Gateway to RTU devices on trans, typically a serial port
+func NewRtuGateway(trans Transport, toms int) CloseableExecutor {
+	return NewGatewayExecutor(NewTransportExecutor(&rtuProtocol{}, trans, toms))
+}
+
+// Delays, skips or busies responses from exec per unit and function
+func NewBehaviorExecutor(exec Executor, opts BehaviorOptions) Executor {
+	e := &behaviorExecutor{}
+	e.exec = exec
+	e.opts = opts
+	e.rand = rand.New(rand.NewSource(opts.Seed))
+	e.counts = make(map[*Behavior]int)
+	return e
+}
+
 func NewModelExecutor(model Model) Executor {
 	exec := &modelExecutor{}
 	exec.model = model
 	return exec
 }
 
+// Wraps a model not safe for concurrent use
+func NewLockedModel(model Model) AtomicModel {
+	m := &lockedModel{}
+	m.model = model
+	return m
+}
+
+// Declare the address spaces before use
+func NewDenseModel() *denseModel {
+	return &denseModel{}
+}
+
+// Wraps model to notify writes and veto executor writes
+func NewObservableModel(model Model) *observableModel {
+	m := &observableModel{}
+	m.model = model
+	m.observers = make(map[*observer]bool)
+	return m
+}
+
+// Saves the model to path every interval until closed.
+// Fails if the interval is not positive or the model
+// cannot enumerate its contents.
+func StartAutosave(model Model, path string, interval time.Duration) (*Autosave, error) {
+	if interval <= 0 {
+		return nil, formatErr("interval invalid %v", interval)
+	}
+	if _, err := TakeSnapshot(model); err != nil {
+		return nil, err
+	}
+	a := &Autosave{}
+	a.model = model
+	a.path = path
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.run(interval)
+	return a, nil
+}
+
+// Nil clock uses the system time, the seed feeds random walks
+func NewSimulator(model Model, clock Clock, seed int64) *Simulator {
+	if clock == nil {
+		clock = &realClock{}
+	}
+	s := &Simulator{}
+	s.model = model
+	s.clock = clock
+	s.rand = rand.New(rand.NewSource(seed))
+	s.start = clock.Now()
+	return s
+}
+
+// Starts at the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
 func NewMapModel() *mapModel {
 	m := &mapModel{}
 	m.dis = make(map[string]bool)
@@ -107,6 +391,18 @@ const (
 	ReadToMs        = 100
 )
 
+// Exception codes
+const (
+	ExIllegalFunction01 byte = 0x01
+	ExIllegalAddress02  byte = 0x02
+	ExIllegalValue03    byte = 0x03
+	ExDeviceFailure04   byte = 0x04
+	ExAcknowledge05     byte = 0x05
+	ExBusy06            byte = 0x06
+	ExGatewayPath0A     byte = 0x0A
+	ExGatewayTarget0B   byte = 0x0B
+)
+
 type Command struct {
 	Slave   byte
 	Code    byte
@@ -146,6 +442,31 @@ type Model interface {
 	WriteWos(slave byte, address uint16, values ...uint16)
 }
 
+// Implemented by models safe for concurrent use.
+// Atomic runs f exclusively so multi register updates
+// and read-modify-write sequences are never observed
+// half applied. Model passed to f must not escape it.
+type AtomicModel interface {
+	Model
+	Atomic(f func(model Model))
+}
+
+// Implemented by models with declared address spaces.
+// Model executors answer exception 02 (illegal data address)
+// when the accessed range is not fully contained.
+type BoundedModel interface {
+	Model
+	Contains(slave byte, area byte, address uint16, count uint16) bool
+}
+
+// Implemented by models that may reject writes.
+// Model executors hand it their write commands, the checker
+// calls write only when accepted and under the same lock
+// as the check so nothing changes in between.
+type WriteChecker interface {
+	CheckWrite(ci *Command, write func(model Model)) error
+}
+
 type Protocol interface {
 	CheckWrapper(buf []byte, length uint16) error
 	MakeBuffers(length uint16) ([]byte, []byte)
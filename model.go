@@ -1,15 +1,76 @@
 package modbus
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
+// Implements: Model, AtomicModel
+// Safe for concurrent use
 type mapModel struct {
-	dis map[string]bool
-	dos map[string]bool
-	wis map[string]uint16
-	wos map[string]uint16
+	mutex sync.RWMutex
+	dis   map[string]bool
+	dos   map[string]bool
+	wis   map[string]uint16
+	wos   map[string]uint16
 }
 
 func (m *mapModel) ReadDis(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readDis(slave, address, count)
+}
+
+func (m *mapModel) ReadDos(slave byte, address uint16, count uint16) []bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readDos(slave, address, count)
+}
+
+func (m *mapModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readWis(slave, address, count)
+}
+
+func (m *mapModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readWos(slave, address, count)
+}
+
+func (m *mapModel) WriteDis(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeDis(slave, address, values...)
+}
+
+func (m *mapModel) WriteDos(slave byte, address uint16, values ...bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeDos(slave, address, values...)
+}
+
+func (m *mapModel) WriteWis(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeWis(slave, address, values...)
+}
+
+func (m *mapModel) WriteWos(slave byte, address uint16, values ...uint16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeWos(slave, address, values...)
+}
+
+// Model passed to f must not be used after f returns
+func (m *mapModel) Atomic(f func(model Model)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f(&mapModelView{m})
+}
+
+func (m *mapModel) readDis(slave byte, address uint16, count uint16) []bool {
 	a := int(address)
 	values := make([]bool, count)
 	for i := range values {
@@ -18,7 +79,7 @@ func (m *mapModel) ReadDis(slave byte, address uint16, count uint16) []bool {
 	return values
 }
 
-func (m *mapModel) ReadDos(slave byte, address uint16, count uint16) []bool {
+func (m *mapModel) readDos(slave byte, address uint16, count uint16) []bool {
 	a := int(address)
 	values := make([]bool, count)
 	for i := range values {
@@ -27,21 +88,21 @@ func (m *mapModel) ReadDos(slave byte, address uint16, count uint16) []bool {
 	return values
 }
 
-func (m *mapModel) WriteDis(slave byte, address uint16, values ...bool) {
+func (m *mapModel) writeDis(slave byte, address uint16, values ...bool) {
 	a := int(address)
 	for i := range values {
 		m.dis[m.Key(slave, a+i)] = values[i]
 	}
 }
 
-func (m *mapModel) WriteDos(slave byte, address uint16, values ...bool) {
+func (m *mapModel) writeDos(slave byte, address uint16, values ...bool) {
 	a := int(address)
 	for i := range values {
 		m.dos[m.Key(slave, a+i)] = values[i]
 	}
 }
 
-func (m *mapModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+func (m *mapModel) readWis(slave byte, address uint16, count uint16) []uint16 {
 	a := int(address)
 	values := make([]uint16, count)
 	for i := range values {
@@ -50,7 +111,7 @@ func (m *mapModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
 	return values
 }
 
-func (m *mapModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+func (m *mapModel) readWos(slave byte, address uint16, count uint16) []uint16 {
 	a := int(address)
 	values := make([]uint16, count)
 	for i := range values {
@@ -59,14 +120,14 @@ func (m *mapModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
 	return values
 }
 
-func (m *mapModel) WriteWis(slave byte, address uint16, values ...uint16) {
+func (m *mapModel) writeWis(slave byte, address uint16, values ...uint16) {
 	a := int(address)
 	for i := range values {
 		m.wis[m.Key(slave, a+i)] = values[i]
 	}
 }
 
-func (m *mapModel) WriteWos(slave byte, address uint16, values ...uint16) {
+func (m *mapModel) writeWos(slave byte, address uint16, values ...uint16) {
 	a := int(address)
 	for i := range values {
 		m.wos[m.Key(slave, a+i)] = values[i]
@@ -76,3 +137,41 @@ func (m *mapModel) WriteWos(slave byte, address uint16, values ...uint16) {
 func (m *mapModel) Key(slave byte, address int) string {
 	return fmt.Sprintf("%d_%04x", slave, address)
 }
+
+// Implements: Model
+// Unlocked access for Atomic callbacks
+type mapModelView struct {
+	m *mapModel
+}
+
+func (v *mapModelView) ReadDis(slave byte, address uint16, count uint16) []bool {
+	return v.m.readDis(slave, address, count)
+}
+
+func (v *mapModelView) ReadDos(slave byte, address uint16, count uint16) []bool {
+	return v.m.readDos(slave, address, count)
+}
+
+func (v *mapModelView) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	return v.m.readWis(slave, address, count)
+}
+
+func (v *mapModelView) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	return v.m.readWos(slave, address, count)
+}
+
+func (v *mapModelView) WriteDis(slave byte, address uint16, values ...bool) {
+	v.m.writeDis(slave, address, values...)
+}
+
+func (v *mapModelView) WriteDos(slave byte, address uint16, values ...bool) {
+	v.m.writeDos(slave, address, values...)
+}
+
+func (v *mapModelView) WriteWis(slave byte, address uint16, values ...uint16) {
+	v.m.writeWis(slave, address, values...)
+}
+
+func (v *mapModelView) WriteWos(slave byte, address uint16, values ...uint16) {
+	v.m.writeWos(slave, address, values...)
+}
@@ -0,0 +1,139 @@
+package modbus
+
+// Largest RTU frame on the wire
+const maxRtuFrame = 256
+
+// Implements: Protocol, broadcaster
+// RTU slave side for shared buses. Scan returns only requests
+// addressed to the configured units. Requests to other units,
+// responses from other slaves and corrupted bytes are skipped
+// resyncing on the next frame with a valid CRC. Only transport
+// errors like io.EOF are returned. Broadcast writes to unit 0
+// are executed as unit 0 and never answered, broadcast reads
+// are skipped.
+type multidropProtocol struct {
+	rtuProtocol
+	units [256]bool
+	buf   []byte
+	gap   bool
+}
+
+func (p *multidropProtocol) Scan(t Transport) (c *Command, err error) {
+	for {
+		var want int
+		c, want = p.next()
+		if c != nil {
+			return
+		}
+		err = p.fill(t, want)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Blocks for the first byte of a frame then reads
+// up to want bytes or until silence
+func (p *multidropProtocol) fill(t Transport, want int) error {
+	if len(p.buf) == 0 {
+		head := make([]byte, 1)
+		_, err := t.TimedRead(head, -1)
+		if err != nil {
+			return err
+		}
+		p.buf = head
+		p.gap = false
+		return nil
+	}
+	rest := make([]byte, want)
+	n, err := t.TimedRead(rest, 0)
+	p.buf = append(p.buf, rest[:n]...)
+	p.gap = n < want
+	if isIoErr(err) {
+		return err
+	}
+	return nil
+}
+
+// Next request for the configured units or
+// the bytes needed to complete a candidate frame
+func (p *multidropProtocol) next() (*Command, int) {
+	for len(p.buf) > 0 {
+		length, request, want := p.frame(p.buf)
+		if want > 0 {
+			return nil, want
+		}
+		if length == 0 {
+			p.buf = p.buf[1:]
+			continue
+		}
+		frame := p.buf[:length]
+		p.buf = p.buf[length:]
+		if !request || !(p.units[frame[0]] || frame[0] == 0) {
+			continue
+		}
+		c := &Command{}
+		if c.DecodeRequest(frame[:length-2]) != nil {
+			continue
+		}
+		//reads are named after themselves
+		if c.Slave == 0 && AreaOf(c.Code) == c.Code {
+			continue
+		}
+		return c, 0
+	}
+	return nil, 0
+}
+
+func (p *multidropProtocol) broadcast(c *Command) bool {
+	return c.Slave == 0
+}
+
+// Length of the frame with a valid CRC at the start of buf
+// and whether it is a request, 0 if none. Want is the count
+// of bytes missing to check the shortest incomplete candidate,
+// candidates cut by silence are discarded.
+func (p *multidropProtocol) frame(buf []byte) (length int, request bool, want int) {
+	if len(buf) < 2 {
+		if p.gap {
+			return 0, false, 0
+		}
+		return 0, false, 2 - len(buf)
+	}
+	code := buf[1]
+	candidates := []int{}
+	requests := 0
+	switch code {
+	case ReadDos01, ReadDis02, ReadWos03, ReadWis04, WriteDo05, WriteWo06:
+		candidates = append(candidates, 8)
+		requests = 1
+		if code <= ReadWis04 && len(buf) > 2 {
+			candidates = append(candidates, 5+int(buf[2]))
+		}
+	case WriteDos15, WriteWos16:
+		if len(buf) > 6 {
+			candidates = append(candidates, 9+int(buf[6]))
+			requests = 1
+		}
+		candidates = append(candidates, 8)
+	default:
+		if code&0x80 != 0 {
+			candidates = append(candidates, 5)
+		}
+	}
+	for i, size := range candidates {
+		if size > maxRtuFrame {
+			continue
+		}
+		if size > len(buf) {
+			if !p.gap && (want == 0 || size-len(buf) < want) {
+				want = size - len(buf)
+			}
+			continue
+		}
+		if p.CheckWrapper(buf[:size], uint16(size-2)) == nil {
+			return size, i < requests, 0
+		}
+	}
+	return 0, false, want
+}
@@ -0,0 +1,252 @@
+package modbus
+
+import "sync"
+
+// A write to a single area, old values read right before it.
+// Areas are named after the read function codes.
+// Only Bools or Words are set depending on the area.
+type Change struct {
+	Slave    byte
+	Area     byte
+	Address  uint16
+	OldBools []bool
+	NewBools []bool
+	OldWords []uint16
+	NewWords []uint16
+}
+
+// Count zero matches the whole area
+type ModelRange struct {
+	Slave   byte
+	Area    byte
+	Address uint16
+	Count   int
+}
+
+func (r *ModelRange) overlaps(slave byte, area byte, address uint16, count int) bool {
+	if r.Slave != slave || r.Area != area {
+		return false
+	}
+	if r.Count == 0 {
+		return true
+	}
+	start := int(r.Address)
+	end := start + r.Count
+	a := int(address)
+	return a < end && a+count > start
+}
+
+func (c *Change) count() int {
+	return len(c.NewBools) + len(c.NewWords)
+}
+
+type observer struct {
+	r      ModelRange
+	notify func(c *Change)
+	veto   func(c *Change) error
+}
+
+// Implements: Model, AtomicModel, BoundedModel, WriteChecker
+// Notifies subscribers after every write and lets veto hooks
+// reject writes coming through the model executor.
+// Callbacks run after the write outside the model lock
+// so they can safely access the model.
+type observableModel struct {
+	model     Model
+	mutex     sync.RWMutex
+	observers map[*observer]bool
+}
+
+// Returns a function that cancels the subscription
+func (m *observableModel) Subscribe(r ModelRange, f func(c *Change)) func() {
+	return m.add(&observer{r: r, notify: f})
+}
+
+// Sends without blocking dropping changes when ch is full
+func (m *observableModel) SubscribeChan(r ModelRange, ch chan<- *Change) func() {
+	return m.Subscribe(r, func(c *Change) {
+		select {
+		case ch <- c:
+		default:
+		}
+	})
+}
+
+// Hooks run before executor writes, returning a *ModbusException
+// rejects the write and sends the exception to the master.
+// Hooks run under the model lock and must not access the model.
+// Direct model writes are not checked.
+func (m *observableModel) Veto(r ModelRange, f func(c *Change) error) func() {
+	return m.add(&observer{r: r, veto: f})
+}
+
+func (m *observableModel) add(o *observer) func() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.observers[o] = true
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.observers, o)
+	}
+}
+
+func (m *observableModel) matching(c *Change, veto bool) (list []*observer) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for o := range m.observers {
+		if (o.veto != nil) != veto {
+			continue
+		}
+		if o.r.overlaps(c.Slave, c.Area, c.Address, c.count()) {
+			list = append(list, o)
+		}
+	}
+	return
+}
+
+// Vetoes and the write run inside a single atomic
+// section, subscribers are notified afterwards
+func (m *observableModel) CheckWrite(ci *Command, write func(model Model)) (err error) {
+	c := &Change{Slave: ci.Slave, Area: AreaOf(ci.Code), Address: ci.Address}
+	switch ci.Code {
+	case WriteDo05:
+		c.NewBools = []bool{ci.Corv == TrueWord}
+	case WriteDos15:
+		c.NewBools = ci.Bools
+	case WriteWo06:
+		c.NewWords = []uint16{ci.Corv}
+	case WriteWos16:
+		c.NewWords = ci.Words
+	}
+	m.Atomic(func(model Model) {
+		list := m.matching(c, true)
+		if len(list) > 0 {
+			if c.NewBools != nil {
+				c.OldBools = model.ReadDos(c.Slave, c.Address, uint16(len(c.NewBools)))
+			} else {
+				c.OldWords = model.ReadWos(c.Slave, c.Address, uint16(len(c.NewWords)))
+			}
+		}
+		for _, o := range list {
+			err = o.veto(c)
+			if err != nil {
+				return
+			}
+		}
+		write(model)
+	})
+	return
+}
+
+func (m *observableModel) Contains(slave byte, area byte, address uint16, count uint16) bool {
+	if bm, ok := m.model.(BoundedModel); ok {
+		return bm.Contains(slave, area, address, count)
+	}
+	return true
+}
+
+func (m *observableModel) ReadDis(slave byte, address uint16, count uint16) []bool {
+	return m.model.ReadDis(slave, address, count)
+}
+
+func (m *observableModel) ReadDos(slave byte, address uint16, count uint16) []bool {
+	return m.model.ReadDos(slave, address, count)
+}
+
+func (m *observableModel) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	return m.model.ReadWis(slave, address, count)
+}
+
+func (m *observableModel) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	return m.model.ReadWos(slave, address, count)
+}
+
+func (m *observableModel) WriteDis(slave byte, address uint16, values ...bool) {
+	m.Atomic(func(model Model) { model.WriteDis(slave, address, values...) })
+}
+
+func (m *observableModel) WriteDos(slave byte, address uint16, values ...bool) {
+	m.Atomic(func(model Model) { model.WriteDos(slave, address, values...) })
+}
+
+func (m *observableModel) WriteWis(slave byte, address uint16, values ...uint16) {
+	m.Atomic(func(model Model) { model.WriteWis(slave, address, values...) })
+}
+
+func (m *observableModel) WriteWos(slave byte, address uint16, values ...uint16) {
+	m.Atomic(func(model Model) { model.WriteWos(slave, address, values...) })
+}
+
+// Notifies after f returns and the inner model is released
+func (m *observableModel) Atomic(f func(model Model)) {
+	view := &observedView{}
+	RunAtomic(m.model, func(model Model) {
+		view.model = model
+		f(view)
+	})
+	for _, c := range view.changes {
+		for _, o := range m.matching(c, false) {
+			o.notify(c)
+		}
+	}
+}
+
+// Implements: Model
+// Records changes made inside an atomic callback
+type observedView struct {
+	model   Model
+	changes []*Change
+}
+
+func (v *observedView) ReadDis(slave byte, address uint16, count uint16) []bool {
+	return v.model.ReadDis(slave, address, count)
+}
+
+func (v *observedView) ReadDos(slave byte, address uint16, count uint16) []bool {
+	return v.model.ReadDos(slave, address, count)
+}
+
+func (v *observedView) ReadWis(slave byte, address uint16, count uint16) []uint16 {
+	return v.model.ReadWis(slave, address, count)
+}
+
+func (v *observedView) ReadWos(slave byte, address uint16, count uint16) []uint16 {
+	return v.model.ReadWos(slave, address, count)
+}
+
+func (v *observedView) WriteDis(slave byte, address uint16, values ...bool) {
+	old := v.model.ReadDis(slave, address, uint16(len(values)))
+	v.model.WriteDis(slave, address, values...)
+	v.add(&Change{Slave: slave, Area: ReadDis02, Address: address, OldBools: old, NewBools: copyBools(values)})
+}
+
+func (v *observedView) WriteDos(slave byte, address uint16, values ...bool) {
+	old := v.model.ReadDos(slave, address, uint16(len(values)))
+	v.model.WriteDos(slave, address, values...)
+	v.add(&Change{Slave: slave, Area: ReadDos01, Address: address, OldBools: old, NewBools: copyBools(values)})
+}
+
+func (v *observedView) WriteWis(slave byte, address uint16, values ...uint16) {
+	old := v.model.ReadWis(slave, address, uint16(len(values)))
+	v.model.WriteWis(slave, address, values...)
+	v.add(&Change{Slave: slave, Area: ReadWis04, Address: address, OldWords: old, NewWords: copyWords(values)})
+}
+
+func (v *observedView) WriteWos(slave byte, address uint16, values ...uint16) {
+	old := v.model.ReadWos(slave, address, uint16(len(values)))
+	v.model.WriteWos(slave, address, values...)
+	v.add(&Change{Slave: slave, Area: ReadWos03, Address: address, OldWords: old, NewWords: copyWords(values)})
+}
+
+func (v *observedView) add(c *Change) {
+	v.changes = append(v.changes, c)
+}
+
+func copyBools(values []bool) []bool {
+	return append([]bool(nil), values...)
+}
+
+func copyWords(values []uint16) []uint16 {
+	return append([]uint16(nil), values...)
+}
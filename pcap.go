@@ -0,0 +1,217 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// Ethernet frames with synthesized IPv4/TCP headers on port 502
+	PcapLinkEthernet = 1
+	// Raw RTU frames, in Wireshark map DLT_USER0 (147) to payload
+	// protocol mbrtu at Preferences > Protocols > DLT_USER
+	PcapLinkRtu = 147
+	PcapTcpPort = 502
+	pcapSnapLen = 65535
+)
+
+// Implements: Transport, Loggable, Measurable
+// Records every Write and TimedRead chunk to a pcapng stream.
+// Bytes consumed internally by DiscardIf are not recorded.
+// Capture stops silently on the first capture write error
+// to keep the wrapped transport working.
+type pcapTransport struct {
+	trans  Transport
+	writer io.Writer
+	link   uint16
+	master bool
+	mutex  sync.Mutex
+	err    error
+	ipid   uint16
+	cseq   uint32 //client to server
+	sseq   uint32 //server to client
+}
+
+func (t *pcapTransport) Close() error {
+	return t.trans.Close()
+}
+
+func (t *pcapTransport) DiscardOn() {
+	t.trans.DiscardOn()
+}
+
+func (t *pcapTransport) DiscardIf() error {
+	return t.trans.DiscardIf()
+}
+
+func (t *pcapTransport) Write(buf []byte) (c int, err error) {
+	c, err = t.trans.Write(buf)
+	if c > 0 {
+		t.capture(buf[:c], true)
+	}
+	return
+}
+
+func (t *pcapTransport) TimedRead(buf []byte, toms int) (c int, err error) {
+	c, err = t.trans.TimedRead(buf, toms)
+	if c > 0 {
+		t.capture(buf[:c], false)
+	}
+	return
+}
+
+func (t *pcapTransport) SetLogger(logger Logger) {
+	if lt, ok := t.trans.(Loggable); ok {
+		lt.SetLogger(logger)
+	}
+}
+
+func (t *pcapTransport) Logger() Logger {
+	return loggerOf(t.trans)
+}
+
+func (t *pcapTransport) SetMetrics(hook MetricsHook) {
+	if mt, ok := t.trans.(Measurable); ok {
+		mt.SetMetrics(hook)
+	}
+}
+
+func (t *pcapTransport) capture(data []byte, outbound bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.err != nil {
+		return
+	}
+	packet := data
+	if t.link == PcapLinkEthernet {
+		//requests flow from master (client) to slave (server)
+		toServer := outbound == t.master
+		packet = t.tcpPacket(data, toServer)
+	}
+	t.err = writePcapPacket(t.writer, time.Now(), packet, outbound)
+}
+
+func (t *pcapTransport) tcpPacket(data []byte, toServer bool) []byte {
+	client := [4]byte{10, 0, 0, 1}
+	server := [4]byte{10, 0, 0, 2}
+	cmac := [6]byte{0x02, 0, 0, 0, 0, 0x01}
+	smac := [6]byte{0x02, 0, 0, 0, 0, 0x02}
+	cport := uint16(49152)
+	sport := uint16(PcapTcpPort)
+	srcip, dstip := client, server
+	srcmac, dstmac := cmac, smac
+	srcport, dstport := cport, sport
+	seq, ack := t.cseq, t.sseq
+	if toServer {
+		t.cseq += uint32(len(data))
+	} else {
+		srcip, dstip = server, client
+		srcmac, dstmac = smac, cmac
+		srcport, dstport = sport, cport
+		seq, ack = t.sseq, t.cseq
+		t.sseq += uint32(len(data))
+	}
+	t.ipid++
+	packet := make([]byte, 14+20+20+len(data))
+	eth := packet[0:14]
+	copy(eth[0:6], dstmac[:])
+	copy(eth[6:12], srcmac[:])
+	binary.BigEndian.PutUint16(eth[12:14], 0x0800)
+	ip := packet[14:34]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+20+len(data)))
+	binary.BigEndian.PutUint16(ip[4:6], t.ipid)
+	binary.BigEndian.PutUint16(ip[6:8], 0x4000) //DF
+	ip[8] = 64
+	ip[9] = 6 //TCP
+	copy(ip[12:16], srcip[:])
+	copy(ip[16:20], dstip[:])
+	binary.BigEndian.PutUint16(ip[10:12], inetChecksum(0, ip))
+	tcp := packet[34:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcport)
+	binary.BigEndian.PutUint16(tcp[2:4], dstport)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 //PSH ACK
+	binary.BigEndian.PutUint16(tcp[14:16], 0xFFFF)
+	copy(tcp[20:], data)
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], srcip[:])
+	copy(pseudo[4:8], dstip[:])
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(tcp)))
+	sum := inetSum(0, pseudo)
+	binary.BigEndian.PutUint16(tcp[16:18], inetChecksum(sum, tcp))
+	return packet
+}
+
+func inetSum(sum uint32, buf []byte) uint32 {
+	for i := 0; i+1 < len(buf); i += 2 {
+		sum += uint32(buf[i])<<8 | uint32(buf[i+1])
+	}
+	if len(buf)%2 == 1 {
+		sum += uint32(buf[len(buf)-1]) << 8
+	}
+	return sum
+}
+
+func inetChecksum(sum uint32, buf []byte) uint16 {
+	sum = inetSum(sum, buf)
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+// Section header plus single interface description
+func writePcapHeader(w io.Writer, link uint16) error {
+	shb := make([]byte, 28)
+	le := binary.LittleEndian
+	le.PutUint32(shb[0:4], 0x0A0D0D0A)
+	le.PutUint32(shb[4:8], 28)
+	le.PutUint32(shb[8:12], 0x1A2B3C4D)
+	le.PutUint16(shb[12:14], 1)
+	le.PutUint16(shb[14:16], 0)
+	le.PutUint64(shb[16:24], 0xFFFFFFFFFFFFFFFF) //unspecified length
+	le.PutUint32(shb[24:28], 28)
+	idb := make([]byte, 20)
+	le.PutUint32(idb[0:4], 1)
+	le.PutUint32(idb[4:8], 20)
+	le.PutUint16(idb[8:10], link)
+	le.PutUint32(idb[12:16], pcapSnapLen)
+	le.PutUint32(idb[16:20], 20)
+	_, err := w.Write(append(shb, idb...))
+	return err
+}
+
+// Enhanced packet block with microsecond timestamp and direction flag
+func writePcapPacket(w io.Writer, ts time.Time, packet []byte, outbound bool) error {
+	padded := (len(packet) + 3) &^ 3
+	total := 28 + padded + 12 + 4
+	epb := make([]byte, total)
+	le := binary.LittleEndian
+	us := uint64(ts.UnixNano() / 1000)
+	le.PutUint32(epb[0:4], 6)
+	le.PutUint32(epb[4:8], uint32(total))
+	le.PutUint32(epb[8:12], 0)
+	le.PutUint32(epb[12:16], uint32(us>>32))
+	le.PutUint32(epb[16:20], uint32(us))
+	le.PutUint32(epb[20:24], uint32(len(packet)))
+	le.PutUint32(epb[24:28], uint32(len(packet)))
+	copy(epb[28:], packet)
+	opts := epb[28+padded:]
+	flags := uint32(1) //inbound
+	if outbound {
+		flags = 2
+	}
+	le.PutUint16(opts[0:2], 2) //epb_flags
+	le.PutUint16(opts[2:4], 4)
+	le.PutUint32(opts[4:8], flags)
+	//opt_endofopt left zeroed at opts[8:12]
+	le.PutUint32(epb[total-4:], uint32(total))
+	_, err := w.Write(epb)
+	return err
+}
@@ -0,0 +1,277 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One JSON line per executed command.
+// Frames are protocol independent PDUs (slave+code+data) in hex.
+// Response holds the exception PDU for modbus exceptions
+// and is empty when Error reports a transport failure.
+// The record transport adds the wire frames in hex.
+type Transaction struct {
+	Time          time.Time `json:"time"`
+	ElapsedUs     int64     `json:"elapsed_us"`
+	Request       string    `json:"request"`
+	Response      string    `json:"response,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	RequestFrame  string    `json:"request_frame,omitempty"`
+	ResponseFrame string    `json:"response_frame,omitempty"`
+}
+
+// Implements: Executor
+// Writes a Transaction per command to a JSON Lines stream.
+// Wrap the transport executor to capture a remote device
+// or the model executor to capture a local slave.
+type recordExecutor struct {
+	exec    Executor
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+func (e *recordExecutor) Execute(ci *Command) (co *Command, err error) {
+	start := time.Now()
+	co, err = e.exec.Execute(ci)
+	elapsed := time.Since(start)
+	tx := &Transaction{}
+	tx.Time = start
+	tx.ElapsedUs = elapsed.Microseconds()
+	tx.Request = hex.EncodeToString(requestPdu(ci))
+	var me *ModbusException
+	if errors.As(err, &me) {
+		tx.Response = hex.EncodeToString([]byte{ci.Slave, ci.Code | 0x80, me.Code})
+	} else if err != nil {
+		tx.Error = firstLine(err.Error())
+	} else {
+		tx.Response = hex.EncodeToString(responsePdu(ci, co))
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	//recording must not break the executor
+	if e.err == nil {
+		e.err = e.encoder.Encode(tx)
+	}
+	return
+}
+
+// Implements: Transport, Loggable
+// Master side recording of wire frames as transactions
+// replayable by the replay executor. Each write starts
+// a transaction that the next read completes. Bytes
+// consumed internally by DiscardIf are not recorded.
+type recordTransport struct {
+	trans   Transport
+	framing string
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+	tx      *Transaction
+	start   time.Time
+}
+
+func (t *recordTransport) Close() error {
+	t.mutex.Lock()
+	t.flush()
+	t.mutex.Unlock()
+	return t.trans.Close()
+}
+
+func (t *recordTransport) DiscardOn() {
+	t.trans.DiscardOn()
+}
+
+func (t *recordTransport) DiscardIf() error {
+	return t.trans.DiscardIf()
+}
+
+// A write without a read is recorded as unanswered
+func (t *recordTransport) Write(buf []byte) (c int, err error) {
+	c, err = t.trans.Write(buf)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.flush()
+	t.start = time.Now()
+	t.tx = &Transaction{}
+	t.tx.Time = t.start
+	t.tx.RequestFrame = hex.EncodeToString(buf[:c])
+	t.tx.Request = hex.EncodeToString(t.unwrap(buf[:c]))
+	if err != nil {
+		t.tx.Error = firstLine(err.Error())
+		t.flush()
+	}
+	return
+}
+
+func (t *recordTransport) TimedRead(buf []byte, toms int) (c int, err error) {
+	c, err = t.trans.TimedRead(buf, toms)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tx == nil {
+		return
+	}
+	//exceptions are short reads ending in a timeout
+	t.tx.ResponseFrame = hex.EncodeToString(buf[:c])
+	t.tx.Response = hex.EncodeToString(t.unwrap(buf[:c]))
+	if c == 0 && err != nil {
+		t.tx.Error = firstLine(err.Error())
+	}
+	t.flush()
+	return
+}
+
+func (t *recordTransport) SetLogger(logger Logger) {
+	if lt, ok := t.trans.(Loggable); ok {
+		lt.SetLogger(logger)
+	}
+}
+
+func (t *recordTransport) Logger() Logger {
+	return loggerOf(t.trans)
+}
+
+// Recording must not break the transport
+func (t *recordTransport) flush() {
+	if t.tx == nil {
+		return
+	}
+	tx := t.tx
+	t.tx = nil
+	tx.ElapsedUs = time.Since(t.start).Microseconds()
+	if tx.Response == "" && tx.Error == "" {
+		tx.Error = ErrNoResponse.Error()
+	}
+	if t.err == nil {
+		t.err = t.encoder.Encode(tx)
+	}
+}
+
+// PDU of a wire frame, empty when too short
+func (t *recordTransport) unwrap(frame []byte) []byte {
+	switch {
+	case t.framing == FramingRtu && len(frame) >= 4:
+		return frame[:len(frame)-2]
+	case t.framing == FramingTcp && len(frame) >= 8:
+		return frame[6:]
+	}
+	return nil
+}
+
+// Implements: Executor
+// Answers commands from recorded transactions.
+// As a master side fake it returns the recorded responses.
+// Under RunSlave it behaves like the captured device
+// including exceptions, delays and missing responses.
+type replayExecutor struct {
+	txs    []*Transaction
+	timed  bool
+	mutex  sync.Mutex
+	cursor int
+}
+
+func (e *replayExecutor) Execute(ci *Command) (co *Command, err error) {
+	request := hex.EncodeToString(requestPdu(ci))
+	tx := e.find(request)
+	if tx == nil {
+		err = formatErr("replay missing request %s", request)
+		return
+	}
+	if e.timed {
+		time.Sleep(time.Duration(tx.ElapsedUs) * time.Microsecond)
+	}
+	if tx.Error != "" {
+		err = &replayError{tx.Error}
+		return
+	}
+	res, err := hex.DecodeString(tx.Response)
+	if err != nil {
+		return
+	}
+	if len(res) == 3 && res[1] == ci.Code|0x80 {
+		err = &ModbusException{res[2]}
+		return
+	}
+	reslen := int(ci.ResponseLength())
+	if len(res) != reslen {
+		err = formatErr("replay length mismatch got %d expected %d", len(res), reslen)
+		return
+	}
+	err = ci.CheckResponse(res)
+	if err != nil {
+		return
+	}
+	co = &Command{}
+	co.DecodeResponse(res, ci.Corv)
+	return
+}
+
+// Next matching transaction after the cursor wrapping around
+func (e *replayExecutor) find(request string) *Transaction {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	count := len(e.txs)
+	for i := 0; i < count; i++ {
+		index := (e.cursor + i) % count
+		tx := e.txs[index]
+		if tx.Request == request {
+			e.cursor = index + 1
+			return tx
+		}
+	}
+	return nil
+}
+
+// Recorded transport failure, the slave does not answer
+type replayError struct {
+	msg string
+}
+
+func (e *replayError) Error() string {
+	return "replay " + e.msg
+}
+
+func (e *replayError) Is(target error) bool {
+	return target == ErrNoResponse
+}
+
+// Reads a JSON Lines stream as written by the record executor
+func ReadTransactions(reader io.Reader) (txs []*Transaction, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		tx := &Transaction{}
+		err = json.Unmarshal([]byte(text), tx)
+		if err != nil {
+			err = formatErr("line %d %s", line, err.Error())
+			return
+		}
+		txs = append(txs, tx)
+	}
+	err = scanner.Err()
+	return
+}
+
+func requestPdu(c *Command) []byte {
+	buf := make([]byte, c.RequestLength())
+	c.EncodeRequest(buf)
+	return buf
+}
+
+func responsePdu(ci *Command, co *Command) []byte {
+	buf := make([]byte, ci.ResponseLength())
+	co.EncodeResponse(buf)
+	return buf
+}
@@ -0,0 +1,374 @@
+package modbus
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A typed value in a slave area.
+// Slave defaults to 1, type to bool for coils and
+// discrete inputs or u16 for registers, order to ABCD.
+// Size is the string length in words and defaults
+// to fit the initial value.
+type Register struct {
+	Slave       byte   `yaml:"slave" json:"slave"`
+	Area        string `yaml:"area" json:"area"`
+	Address     uint16 `yaml:"address" json:"address"`
+	Type        string `yaml:"type" json:"type"`
+	Order       string `yaml:"order" json:"order"`
+	Size        int    `yaml:"size" json:"size"`
+	Value       string `yaml:"value" json:"value"`
+	ReadOnly    bool   `yaml:"readonly" json:"readonly"`
+	Description string `yaml:"description" json:"description"`
+}
+
+// Applies the slave default to YAML entries
+func (r *Register) UnmarshalYAML(node *yaml.Node) error {
+	type plain Register
+	reg := plain{Slave: 1}
+	if err := node.Decode(&reg); err != nil {
+		return err
+	}
+	*r = Register(reg)
+	return nil
+}
+
+type RegisterMap struct {
+	Registers []*Register `yaml:"registers" json:"registers"`
+}
+
+// Area names accepted: coil do 0x, di 1x, hr wo 4x, ir wi 3x
+// and the read function code number. Returns the read code.
+func ParseArea(name string) (byte, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "coil", "coils", "do", "dos", "0x", "1":
+		return ReadDos01, nil
+	case "di", "dis", "discrete", "1x", "2":
+		return ReadDis02, nil
+	case "hr", "holding", "wo", "wos", "4x", "3":
+		return ReadWos03, nil
+	case "ir", "input", "wi", "wis", "3x", "4":
+		return ReadWis04, nil
+	}
+	return 0, formatErr("area unsupported %q", name)
+}
+
+// Short name for a read function code
+func AreaName(area byte) string {
+	switch area {
+	case ReadDos01:
+		return "coil"
+	case ReadDis02:
+		return "di"
+	case ReadWos03:
+		return "hr"
+	case ReadWis04:
+		return "ir"
+	}
+	return strconv.Itoa(int(area))
+}
+
+// Chooses CSV or YAML by file extension
+func LoadRegisterMap(path string) (*RegisterMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ReadRegisterMapYaml(file)
+	case ".csv":
+		return ReadRegisterMapCsv(file)
+	}
+	return nil, formatErr("extension unsupported %s", path)
+}
+
+// Top level registers list or a plain list of registers
+func ReadRegisterMapYaml(reader io.Reader) (*RegisterMap, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	rm := &RegisterMap{}
+	err = yaml.Unmarshal(data, rm)
+	if err != nil {
+		err = yaml.Unmarshal(data, &rm.Registers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rm, rm.normalize()
+}
+
+// First row names the columns, case insensitive and in any order:
+// slave, area, address, type, order, size, value, readonly, description.
+// Rows starting with # are skipped.
+func ReadRegisterMapCsv(reader io.Reader) (*RegisterMap, error) {
+	cr := csv.NewReader(reader)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[csvColumn(name)] = i
+	}
+	if _, ok := columns["area"]; !ok {
+		return nil, formatErr("column missing area")
+	}
+	if _, ok := columns["address"]; !ok {
+		return nil, formatErr("column missing address")
+	}
+	rm := &RegisterMap{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		reg := &Register{Slave: 1}
+		reg.Area = field("area")
+		reg.Type = field("type")
+		reg.Order = field("order")
+		reg.Value = field("value")
+		reg.Description = field("description")
+		reg.ReadOnly, err = parseFlag(field("readonly"))
+		if err == nil && field("slave") != "" {
+			var slave uint64
+			slave, err = strconv.ParseUint(field("slave"), 0, 8)
+			reg.Slave = byte(slave)
+		}
+		if err == nil {
+			var address uint64
+			address, err = strconv.ParseUint(field("address"), 0, 16)
+			reg.Address = uint16(address)
+		}
+		if err == nil && field("size") != "" {
+			reg.Size, err = strconv.Atoi(field("size"))
+		}
+		if err != nil {
+			return nil, formatErr("line %d %s", line, err.Error())
+		}
+		rm.Registers = append(rm.Registers, reg)
+	}
+	return rm, rm.normalize()
+}
+
+func csvColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "byte order", "byteorder", "byte_order":
+		return "order"
+	case "initial", "initial value", "initial_value":
+		return "value"
+	case "read-only", "read only", "read_only", "ro":
+		return "readonly"
+	case "desc":
+		return "description"
+	case "unit":
+		return "slave"
+	}
+	return name
+}
+
+func parseFlag(text string) (bool, error) {
+	switch strings.ToLower(text) {
+	case "", "0", "false", "no", "n":
+		return false, nil
+	case "1", "true", "yes", "y", "x":
+		return true, nil
+	}
+	return false, formatErr("flag invalid %q", text)
+}
+
+// Applies defaults and validates types, orders and overlaps
+func (rm *RegisterMap) normalize() error {
+	used := make(map[[2]byte]map[int]*Register)
+	for i, reg := range rm.Registers {
+		area, err := ParseArea(reg.Area)
+		if err != nil {
+			return formatErr("register %d %s", i, err.Error())
+		}
+		if reg.Slave == 0 {
+			return formatErr("register %d at %d slave 0 is broadcast only", i, reg.Address)
+		}
+		bits := area == ReadDos01 || area == ReadDis02
+		if reg.Type == "" {
+			reg.Type = TypeU16
+			if bits {
+				reg.Type = TypeBool
+			}
+		}
+		reg.Type = strings.ToLower(reg.Type)
+		err = CheckType(reg.Type)
+		if err == nil && bits != (reg.Type == TypeBool) {
+			err = formatErr("type %s invalid for area %s", reg.Type, reg.Area)
+		}
+		if err == nil {
+			err = CheckOrder(reg.Order)
+		}
+		if err == nil && reg.Type == TypeString && reg.Size == 0 {
+			reg.Size = (len(reg.Value) + 1) / 2
+			if reg.Size == 0 {
+				reg.Size = 1
+			}
+		}
+		if err == nil {
+			_, err = reg.Encode()
+		}
+		if err == nil && int(reg.Address)+reg.Count() > 0x10000 {
+			err = formatErr("address %d overflows", reg.Address)
+		}
+		if err != nil {
+			return formatErr("register %d at %d %s", i, reg.Address, err.Error())
+		}
+		key := [2]byte{reg.Slave, area}
+		if used[key] == nil {
+			used[key] = make(map[int]*Register)
+		}
+		for a := int(reg.Address); a < int(reg.Address)+reg.Count(); a++ {
+			if other, ok := used[key][a]; ok {
+				return formatErr("register %d at %d overlaps register at %d", i, reg.Address, other.Address)
+			}
+			used[key][a] = reg
+		}
+	}
+	return nil
+}
+
+// Read function code of the area, 0 if invalid
+func (r *Register) AreaCode() byte {
+	area, _ := ParseArea(r.Area)
+	return area
+}
+
+// Addresses taken
+func (r *Register) Count() int {
+	switch r.Type {
+	case TypeBool:
+		return 1
+	case TypeString:
+		return r.Size
+	}
+	return TypeWords(r.Type)
+}
+
+// Initial value packed into words, nil for bools
+func (r *Register) Encode() ([]uint16, error) {
+	value, err := ParseValue(r.Type, r.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Type {
+	case TypeBool:
+		return nil, nil
+	case TypeString:
+		return EncodeString(r.Order, value.(string), r.Size)
+	}
+	return EncodeValue(r.Type, r.Order, value)
+}
+
+// Dense model spanning each slave area from its lowest to its
+// highest register loaded with the initial values. Only listed
+// addresses are readable and writable, gaps between registers
+// and executor writes to read only registers are answered
+// with exception 02.
+func NewRegisterModel(rm *RegisterMap) (*observableModel, error) {
+	type span struct{ start, end int }
+	spans := make(map[[2]byte]*span)
+	listed := make(map[[2]byte]map[int]bool)
+	for _, reg := range rm.Registers {
+		key := [2]byte{reg.Slave, reg.AreaCode()}
+		start, end := int(reg.Address), int(reg.Address)+reg.Count()
+		if listed[key] == nil {
+			listed[key] = make(map[int]bool)
+		}
+		for a := start; a < end; a++ {
+			listed[key][a] = true
+		}
+		s, ok := spans[key]
+		if !ok {
+			spans[key] = &span{start, end}
+			continue
+		}
+		if start < s.start {
+			s.start = start
+		}
+		if end > s.end {
+			s.end = end
+		}
+	}
+	dense := NewDenseModel()
+	for key, s := range spans {
+		err := dense.Declare(key[0], key[1], uint16(s.start), s.end-s.start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, reg := range rm.Registers {
+		words, err := reg.Encode()
+		if err != nil {
+			return nil, err
+		}
+		switch reg.AreaCode() {
+		case ReadDos01:
+			value, _ := ParseValue(TypeBool, reg.Value)
+			dense.WriteDos(reg.Slave, reg.Address, value.(bool))
+		case ReadDis02:
+			value, _ := ParseValue(TypeBool, reg.Value)
+			dense.WriteDis(reg.Slave, reg.Address, value.(bool))
+		case ReadWos03:
+			dense.WriteWos(reg.Slave, reg.Address, words...)
+		case ReadWis04:
+			dense.WriteWis(reg.Slave, reg.Address, words...)
+		}
+	}
+	model := NewObservableModel(&registerModel{dense, listed})
+	for _, reg := range rm.Registers {
+		if !reg.ReadOnly {
+			continue
+		}
+		r := ModelRange{reg.Slave, reg.AreaCode(), reg.Address, reg.Count()}
+		model.Veto(r, func(c *Change) error {
+			return &ModbusException{ExIllegalAddress02}
+		})
+	}
+	return model, nil
+}
+
+// Implements: Model, AtomicModel, BoundedModel
+// Dense model bounded to the listed register addresses
+type registerModel struct {
+	*denseModel
+	listed map[[2]byte]map[int]bool
+}
+
+func (m *registerModel) Contains(slave byte, area byte, address uint16, count uint16) bool {
+	listed := m.listed[[2]byte{slave, area}]
+	for a := int(address); a < int(address)+int(count); a++ {
+		if !listed[a] {
+			return false
+		}
+	}
+	return true
+}
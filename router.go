@@ -0,0 +1,66 @@
+package modbus
+
+import "sync"
+
+// Implements: Executor
+// Dispatches commands by unit id to per unit executors.
+// Unrouted units go to the default route when set, otherwise
+// TCP routers answer exception 0B and RTU routers stay silent.
+// Routes can be changed while serving.
+type Router struct {
+	mutex    sync.RWMutex
+	routes   map[byte]Executor
+	fallback Executor
+	silent   bool
+}
+
+func (r *Router) Route(unit byte, exec Executor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes[unit] = exec
+}
+
+func (r *Router) RouteModel(unit byte, model Model) {
+	r.Route(unit, NewModelExecutor(model))
+}
+
+// Nil removes the default route
+func (r *Router) Default(exec Executor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.fallback = exec
+}
+
+func (r *Router) Remove(unit byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.routes, unit)
+}
+
+func (r *Router) Units() []byte {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	units := make([]byte, 0, len(r.routes))
+	for unit := 0; unit < 256; unit++ {
+		if _, ok := r.routes[byte(unit)]; ok {
+			units = append(units, byte(unit))
+		}
+	}
+	return units
+}
+
+func (r *Router) Execute(ci *Command) (*Command, error) {
+	r.mutex.RLock()
+	exec, ok := r.routes[ci.Slave]
+	if !ok {
+		exec = r.fallback
+	}
+	r.mutex.RUnlock()
+	if exec != nil {
+		return exec.Execute(ci)
+	}
+	if r.silent {
+		return nil, ErrNoResponse
+	}
+	return nil, &ModbusException{ExGatewayTarget0B}
+}
@@ -0,0 +1,318 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type UnitStatus int
+
+const (
+	UnitTimeout UnitStatus = iota
+	UnitException
+	UnitData
+	UnitFraming //answers failing the protocol checks
+)
+
+func (s UnitStatus) String() string {
+	switch s {
+	case UnitTimeout:
+		return "timeout"
+	case UnitException:
+		return "exception"
+	case UnitData:
+		return "data"
+	case UnitFraming:
+		return "framing"
+	default:
+		return "unknown"
+	}
+}
+
+// Areas are named after the read function codes
+var ScanAreas = []byte{ReadDos01, ReadDis02, ReadWos03, ReadWis04}
+
+// Zero values select all units 1-247, all areas and
+// the full address space with no delay between requests.
+// The master timeout bounds each probe so it should be short.
+type ScanOptions struct {
+	Units    []byte
+	Areas    []byte
+	Start    uint16
+	End      uint16 //inclusive, 0 means 0xFFFF
+	Interval time.Duration
+	//called after each unit probe when not nil
+	OnProbe func(probe *UnitProbe)
+}
+
+type UnitProbe struct {
+	Unit      byte
+	Status    UnitStatus
+	Exception byte
+	Err       error
+}
+
+type AddressRange struct {
+	Start uint16 `json:"start"`
+	Count int    `json:"count"`
+}
+
+type AreaMap struct {
+	Code   byte            `json:"code"`
+	Name   string          `json:"name"`
+	Ranges []*AddressRange `json:"ranges"`
+}
+
+type UnitMap struct {
+	Unit      byte       `json:"unit"`
+	Status    string     `json:"status"`
+	Exception byte       `json:"exception,omitempty"`
+	Areas     []*AreaMap `json:"areas,omitempty"`
+}
+
+type DeviceMap struct {
+	Units []*UnitMap `json:"units"`
+}
+
+// Probes units and maps readable address ranges through a master
+type Scanner struct {
+	master Master
+	opts   ScanOptions
+	last   time.Time
+}
+
+// Probes each unit with a single holding register read.
+// Timeouts mean absent, exceptions and data mean present and
+// framing means something answered with corrupted responses.
+// I/O errors end the probe returning the units probed so far.
+func (s *Scanner) ProbeUnits(ctx context.Context) (probes []*UnitProbe, err error) {
+	for _, unit := range s.units() {
+		err = s.wait(ctx)
+		if err != nil {
+			return
+		}
+		probe := &UnitProbe{Unit: unit}
+		_, perr := s.master.ReadWo(unit, s.opts.Start)
+		if isIoErr(perr) {
+			err = perr
+			return
+		}
+		probe.Status, probe.Exception = classifyProbe(perr)
+		if probe.Status == UnitTimeout || probe.Status == UnitFraming {
+			probe.Err = perr
+		}
+		if s.opts.OnProbe != nil {
+			s.opts.OnProbe(probe)
+		}
+		probes = append(probes, probe)
+	}
+	return
+}
+
+// Probes units and maps the readable ranges of responding ones.
+// Units with corrupted responses are listed without areas.
+func (s *Scanner) Scan(ctx context.Context) (dm *DeviceMap, err error) {
+	probes, err := s.ProbeUnits(ctx)
+	if err != nil {
+		return
+	}
+	dm = &DeviceMap{}
+	for _, probe := range probes {
+		if probe.Status == UnitTimeout {
+			continue
+		}
+		um := &UnitMap{}
+		um.Unit = probe.Unit
+		um.Status = probe.Status.String()
+		um.Exception = probe.Exception
+		if probe.Status == UnitFraming {
+			dm.Units = append(dm.Units, um)
+			continue
+		}
+		for _, code := range s.areas() {
+			am := &AreaMap{Code: code, Name: CodeName(code)}
+			am.Ranges, err = s.ScanArea(ctx, probe.Unit, code)
+			if err != nil {
+				return
+			}
+			um.Areas = append(um.Areas, am)
+		}
+		dm.Units = append(dm.Units, um)
+	}
+	return
+}
+
+// Reads blocks of the maximum read count. For an unreadable
+// block the readable prefix and suffix are found by binary
+// search and the addresses in between are taken as a gap, so
+// readable islands strictly inside a gap are not reported.
+// Adjacent readable blocks are merged into a single range.
+// A readable block costs 1 request, an unreadable one 3 and
+// one holding a range edge about 2*log2(count)+1, near 15.
+// Full address spaces cost about 525 requests per area when
+// readable and about 1600 when unmapped. I/O errors end the scan.
+func (s *Scanner) ScanArea(ctx context.Context, unit byte, code byte) (ranges []*AddressRange, err error) {
+	max := int(MaxWords)
+	if code == ReadDos01 || code == ReadDis02 {
+		max = int(MaxBools)
+	}
+	end := int(s.opts.End)
+	if end == 0 {
+		end = 0xFFFF
+	}
+	add := func(start int, count int) {
+		if count == 0 {
+			return
+		}
+		if len(ranges) > 0 {
+			last := ranges[len(ranges)-1]
+			if int(last.Start)+last.Count == start {
+				last.Count += count
+				return
+			}
+		}
+		ranges = append(ranges, &AddressRange{uint16(start), count})
+	}
+	block := func(start int, count int) error {
+		err := s.wait(ctx)
+		if err != nil {
+			return err
+		}
+		ok, err := s.readable(unit, code, start, count)
+		if err != nil {
+			return err
+		}
+		if ok {
+			add(start, count)
+			return nil
+		}
+		prefix, err := s.edge(ctx, count-1, func(n int) (bool, error) {
+			return s.readable(unit, code, start, n)
+		})
+		if err != nil {
+			return err
+		}
+		add(start, prefix)
+		//start+prefix is unreadable
+		suffix, err := s.edge(ctx, count-prefix-1, func(n int) (bool, error) {
+			return s.readable(unit, code, start+count-n, n)
+		})
+		if err != nil {
+			return err
+		}
+		add(start+count-suffix, suffix)
+		return nil
+	}
+	for start := int(s.opts.Start); start <= end; start += max {
+		count := max
+		if start+count > end+1 {
+			count = end + 1 - start
+		}
+		err = block(start, count)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Largest n in [0, max] with ok(n) for ok true up to
+// the edge and false after it. Tries 1 first so edges
+// at the block start cost a single request.
+func (s *Scanner) edge(ctx context.Context, max int, ok func(n int) (bool, error)) (int, error) {
+	lo, hi := 0, max+1
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if lo == 0 {
+			mid = 1
+		}
+		err := s.wait(ctx)
+		if err != nil {
+			return 0, err
+		}
+		found, err := ok(mid)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// Only I/O errors are returned
+func (s *Scanner) readable(unit byte, code byte, start int, count int) (bool, error) {
+	address := uint16(start)
+	var err error
+	switch code {
+	case ReadDos01:
+		_, err = s.master.ReadDos(unit, address, uint16(count))
+	case ReadDis02:
+		_, err = s.master.ReadDis(unit, address, uint16(count))
+	case ReadWos03:
+		_, err = s.master.ReadWos(unit, address, uint16(count))
+	case ReadWis04:
+		_, err = s.master.ReadWis(unit, address, uint16(count))
+	default:
+		return false, nil
+	}
+	if isIoErr(err) {
+		return false, err
+	}
+	return err == nil, nil
+}
+
+// Rate limits requests and checks for cancelation
+func (s *Scanner) wait(ctx context.Context) error {
+	if s.opts.Interval > 0 && !s.last.IsZero() {
+		delay := s.opts.Interval - time.Since(s.last)
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	s.last = time.Now()
+	return ctx.Err()
+}
+
+func (s *Scanner) units() []byte {
+	if len(s.opts.Units) > 0 {
+		return s.opts.Units
+	}
+	units := make([]byte, 0, 247)
+	for unit := 1; unit <= 247; unit++ {
+		units = append(units, byte(unit))
+	}
+	return units
+}
+
+func (s *Scanner) areas() []byte {
+	if len(s.opts.Areas) > 0 {
+		return s.opts.Areas
+	}
+	return ScanAreas
+}
+
+func classifyProbe(err error) (UnitStatus, byte) {
+	if err == nil {
+		return UnitData, 0
+	}
+	var me *ModbusException
+	switch {
+	case errors.As(err, &me):
+		return UnitException, me.Code
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrNoResponse):
+		return UnitTimeout, 0
+	default:
+		return UnitFraming, 0
+	}
+}
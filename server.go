@@ -0,0 +1,237 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Returned by Serve after Shutdown or Close
+var ErrServerClosed = errors.New("server closed")
+
+// Zero values mean unlimited connections and no idle timeout
+type ServerOptions struct {
+	MaxConns    int
+	IdleTimeout time.Duration
+	//applied to every connection transport when not nil
+	Logger Logger
+	//receives the commands, framing errors and bytes
+	//of every connection when not nil
+	Metrics MetricsHook
+	//wraps the shared executor per connection when not nil
+	ConnExecutor func(conn net.Conn, exec Executor) Executor
+}
+
+// Serves each accepted connection concurrently with its own Slave.
+// The executor is shared so it must be safe for concurrent use.
+type Server struct {
+	factory  func() Protocol
+	exec     Executor
+	opts     ServerOptions
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    map[*serverConn]bool
+	closing  bool
+}
+
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Blocks until the listener fails or the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		sc := s.track(conn)
+		if sc == nil {
+			conn.Close()
+			continue
+		}
+		go s.serve(sc)
+	}
+}
+
+// Addr returns nil until Serve is called
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Active connections count
+func (s *Server) Conns() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// Stops accepting, lets in flight requests complete and closes idle
+// connections. Remaining connections are closed when ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop()
+	ticker := time.NewTicker(durationMs(ReadToMs))
+	defer ticker.Stop()
+	for {
+		if s.Conns() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stops accepting and closes all connections immediately
+func (s *Server) Close() error {
+	err := s.stop()
+	s.closeConns()
+	return err
+}
+
+func (s *Server) stop() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return
+	}
+	s.closing = true
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	return
+}
+
+func (s *Server) closeConns() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closing
+}
+
+// nil if closing or over the connection limit
+func (s *Server) track(conn net.Conn) *serverConn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return nil
+	}
+	if s.opts.MaxConns > 0 && len(s.conns) >= s.opts.MaxConns {
+		return nil
+	}
+	sc := &serverConn{}
+	sc.conn = conn
+	sc.reader = NewConnTimedReader(conn)
+	sc.server = s
+	sc.last = time.Now()
+	s.conns[sc] = true
+	return sc
+}
+
+func (s *Server) untrack(sc *serverConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns, sc)
+}
+
+func (s *Server) serve(sc *serverConn) {
+	defer s.untrack(sc)
+	defer sc.conn.Close()
+	trans := NewIoTransport(sc, sc.conn)
+	if s.opts.Logger != nil {
+		trans.(Loggable).SetLogger(s.opts.Logger)
+	}
+	exec := s.exec
+	if s.opts.ConnExecutor != nil {
+		exec = s.opts.ConnExecutor(sc.conn, exec)
+	}
+	slave := NewSlave(s.factory(), trans, exec, SlaveOptions{Metrics: s.opts.Metrics, Logger: s.opts.Logger})
+	slave.served = sc.idle
+	slave.Run()
+}
+
+// Implements: TimedReader, io.WriteCloser
+// Reports io.EOF while idle on shutdown or idle timeout
+type serverConn struct {
+	conn   net.Conn
+	reader TimedReader
+	server *Server
+	mutex  sync.Mutex
+	busy   bool
+	last   time.Time
+}
+
+func (c *serverConn) TimedRead(buf []byte) (n int, err error) {
+	n, err = c.reader.TimedRead(buf)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n > 0 {
+		c.busy = true
+		c.last = time.Now()
+		return
+	}
+	if c.busy {
+		return
+	}
+	if c.server.isClosing() {
+		return 0, io.EOF
+	}
+	idle := c.server.opts.IdleTimeout
+	if idle > 0 && time.Since(c.last) >= idle {
+		return 0, io.EOF
+	}
+	return
+}
+
+func (c *serverConn) Write(buf []byte) (int, error) {
+	return c.conn.Write(buf)
+}
+
+func (c *serverConn) Close() error {
+	return c.conn.Close()
+}
+
+// Called after each request completes
+func (c *serverConn) idle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.busy = false
+	c.last = time.Now()
+}
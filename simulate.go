@@ -0,0 +1,305 @@
+package modbus
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Generator kinds
+const (
+	GenRamp    = "ramp"    //min to max every period
+	GenSine    = "sine"    //between min and max with period
+	GenWalk    = "walk"    //random steps up to step size within min and max
+	GenCounter = "counter" //adds step every period wrapping from max to min
+	GenSquare  = "square"  //max first half of period then min
+	GenLag     = "lag"     //follows the source register with time constant tau
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct {
+}
+
+func (c *realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Implements: Clock
+// Only moves when advanced
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Drives a single address or typed register.
+// Coils and discrete inputs are true when the value is not zero.
+// Type and Order apply to registers, u16 and ABCD by default.
+// Integer types are rounded and clamped to their range.
+// Source is the register followed by lag generators, it is read
+// with the same type and order.
+type Generator struct {
+	Slave   byte
+	Area    byte
+	Address uint16
+	Kind    string
+	Type    string
+	Order   string
+	Min     float64
+	Max     float64
+	Period  time.Duration
+	Step    float64
+	Tau     time.Duration
+	Source  ModelRange
+}
+
+type genState struct {
+	gen   *Generator
+	value float64
+	last  time.Time
+	init  bool
+}
+
+// Writes generated values to a model on every step
+type Simulator struct {
+	mutex sync.Mutex
+	model Model
+	clock Clock
+	rand  *rand.Rand
+	start time.Time
+	gens  []*genState
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func (s *Simulator) Add(gen *Generator) error {
+	if gen.Type == "" {
+		gen.Type = TypeU16
+	}
+	bits := gen.Area == ReadDos01 || gen.Area == ReadDis02
+	if !bits && TypeWords(gen.Type) == 0 {
+		return formatErr("type unsupported %s", gen.Type)
+	}
+	err := CheckOrder(gen.Order)
+	if err != nil {
+		return err
+	}
+	switch gen.Kind {
+	case GenRamp, GenSine, GenCounter, GenSquare:
+		if gen.Period <= 0 {
+			return formatErr("period required for %s", gen.Kind)
+		}
+	case GenWalk:
+	case GenLag:
+		if gen.Tau <= 0 {
+			return formatErr("tau required for %s", gen.Kind)
+		}
+	default:
+		return formatErr("generator unsupported %s", gen.Kind)
+	}
+	if AreaOf(gen.Area) != gen.Area || gen.Area == 0 {
+		return formatErr("area unsupported %d", gen.Area)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gens = append(s.gens, &genState{gen: gen, value: gen.Min})
+	return nil
+}
+
+// Computes all generators at the current clock time
+// and writes them in a single atomic operation
+func (s *Simulator) Step() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	elapsed := now.Sub(s.start)
+	RunAtomic(s.model, func(m Model) {
+		for _, gs := range s.gens {
+			value := s.compute(m, gs, now, elapsed)
+			s.write(m, gs.gen, value)
+		}
+	})
+}
+
+// Steps every interval until stopped, fails if
+// the interval is not positive
+func (s *Simulator) Start(interval time.Duration) error {
+	if interval <= 0 {
+		return formatErr("interval invalid %v", interval)
+	}
+	s.mutex.Lock()
+	if s.stop != nil {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	stop, done := s.stop, s.done
+	s.mutex.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Step()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Simulator) Stop() {
+	s.mutex.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (s *Simulator) compute(m Model, gs *genState, now time.Time, elapsed time.Duration) float64 {
+	g := gs.gen
+	span := g.Max - g.Min
+	phase := 0.0
+	if g.Period > 0 {
+		phase = math.Mod(float64(elapsed), float64(g.Period)) / float64(g.Period)
+	}
+	switch g.Kind {
+	case GenRamp:
+		gs.value = g.Min + span*phase
+	case GenSine:
+		gs.value = g.Min + span*(1+math.Sin(2*math.Pi*phase))/2
+	case GenSquare:
+		gs.value = g.Min
+		if phase < 0.5 {
+			gs.value = g.Max
+		}
+	case GenCounter:
+		steps := math.Floor(float64(elapsed) / float64(g.Period))
+		size := math.Floor(span/g.Step) + 1
+		if g.Step <= 0 || size < 1 {
+			size = 1
+		}
+		gs.value = g.Min + math.Mod(steps, size)*g.Step
+	case GenWalk:
+		gs.value += (2*s.rand.Float64() - 1) * g.Step
+		gs.value = math.Max(g.Min, math.Min(g.Max, gs.value))
+	case GenLag:
+		sp := s.read(m, g)
+		if !gs.init {
+			gs.init = true
+			gs.last = now
+		}
+		dt := now.Sub(gs.last)
+		gs.last = now
+		gs.value += (sp - gs.value) * (1 - math.Exp(-float64(dt)/float64(g.Tau)))
+	}
+	return gs.value
+}
+
+func (s *Simulator) read(m Model, g *Generator) float64 {
+	src := g.Source
+	switch src.Area {
+	case ReadDos01:
+		return boolFloat(m.ReadDos(src.Slave, src.Address, 1)[0])
+	case ReadDis02:
+		return boolFloat(m.ReadDis(src.Slave, src.Address, 1)[0])
+	}
+	count := uint16(TypeWords(g.Type))
+	var words []uint16
+	if src.Area == ReadWis04 {
+		words = m.ReadWis(src.Slave, src.Address, count)
+	} else {
+		words = m.ReadWos(src.Slave, src.Address, count)
+	}
+	value, err := DecodeValue(g.Type, g.Order, words)
+	if err != nil {
+		return 0
+	}
+	f, _ := toFloat(value)
+	return f
+}
+
+func (s *Simulator) write(m Model, g *Generator, value float64) {
+	switch g.Area {
+	case ReadDos01:
+		m.WriteDos(g.Slave, g.Address, value != 0)
+		return
+	case ReadDis02:
+		m.WriteDis(g.Slave, g.Address, value != 0)
+		return
+	}
+	var words []uint16
+	var err error
+	if g.Type == TypeF32 || g.Type == TypeF64 {
+		words, err = EncodeValue(g.Type, g.Order, value)
+	} else {
+		words, err = EncodeValue(g.Type, g.Order, clampInt(g.Type, value))
+	}
+	if err != nil {
+		return
+	}
+	if g.Area == ReadWis04 {
+		m.WriteWis(g.Slave, g.Address, words...)
+	} else {
+		m.WriteWos(g.Slave, g.Address, words...)
+	}
+}
+
+// Rounds into the range of an integer type,
+// NaN maps to zero
+func clampInt(dtype string, value float64) interface{} {
+	size := TypeWords(dtype) * 16
+	if math.IsNaN(value) {
+		value = 0
+	}
+	value = math.Round(value)
+	switch dtype {
+	case TypeI16, TypeI32, TypeI64:
+		max := int64(uint64(1)<<(size-1) - 1)
+		limit := math.Ldexp(1, size-1)
+		switch {
+		case value >= limit:
+			return max
+		case value < -limit:
+			return -max - 1
+		}
+		return int64(value)
+	}
+	max := ^uint64(0) >> (64 - size)
+	switch {
+	case value <= 0:
+		return uint64(0)
+	case value >= math.Ldexp(1, size):
+		return max
+	}
+	return uint64(value)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,16 +1,153 @@
 package modbus
 
+import (
+	"errors"
+	"sync"
+)
+
+// Matches with errors.Is the scan errors of malformed requests
+var ErrFraming = errors.New("framing")
+
+type framingError struct {
+	err error
+}
+
+func (e *framingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *framingError) Unwrap() error {
+	return e.err
+}
+
+func (e *framingError) Is(target error) bool {
+	return target == ErrFraming
+}
+
+// Implemented by protocols with requests that
+// are executed but never answered
+type broadcaster interface {
+	broadcast(c *Command) bool
+}
+
+// Slave loop policies on framing errors
+const (
+	FramingResync = iota //discard pending input and keep serving
+	FramingClose         //end the loop with the error
+)
+
+// MaxFraming ends the loop after that many framing
+// errors in a row regardless of policy, 0 for no limit.
+// Metrics receives the served commands, the framing
+// errors and the transport bytes when measurable.
+// Logger receives the slave loop entries, the transport
+// logger or the default logger are used when nil.
+type SlaveOptions struct {
+	Framing    int
+	MaxFraming int
+	Metrics    MetricsHook
+	Logger     Logger
+}
+
+type SlaveStats struct {
+	Requests   uint64
+	Exceptions uint64
+	Framing    uint64
+}
+
+// Serves a transport until an I/O error
+type Slave struct {
+	proto Protocol
+	trans Transport
+	exec  Executor
+	opts  SlaveOptions
+	mutex sync.Mutex
+	stats SlaveStats
+	//called after each request when not nil
+	served func()
+}
+
+func (s *Slave) Stats() SlaveStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
+
+// Framing errors are logged to the options logger
+// or else to the transport logger
+func (s *Slave) Run() error {
+	logger := s.opts.Logger
+	if logger == nil {
+		logger = orDefaultLogger(loggerOf(s.trans))
+	}
+	exec := s.exec
+	if s.opts.Metrics != nil {
+		exec = Chain(exec, MetricsMiddleware(s.opts.Metrics, SideSlave))
+		if mt, ok := s.trans.(Measurable); ok {
+			mt.SetMetrics(s.opts.Metrics)
+		}
+	}
+	row := 0
+	for {
+		exception, err := runOneSlave(s.proto, s.trans, exec, logger, s.opts.Metrics)
+		if s.served != nil {
+			s.served()
+		}
+		framing := errors.Is(err, ErrFraming)
+		s.mutex.Lock()
+		if err == nil {
+			s.stats.Requests++
+		}
+		if exception {
+			s.stats.Exceptions++
+		}
+		if framing {
+			s.stats.Framing++
+		}
+		s.mutex.Unlock()
+		if !framing {
+			row = 0
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		logFrame(logger, "e", "<", nil, nil, err)
+		if s.opts.Metrics != nil {
+			s.opts.Metrics.Framing(err)
+		}
+		row++
+		if s.opts.Framing == FramingClose {
+			return err
+		}
+		if s.opts.MaxFraming > 0 && row >= s.opts.MaxFraming {
+			return err
+		}
+	}
+}
+
 func ApplyToExecutor(ci *Command, p Protocol, e Executor) (co *Command, fbuf []byte, err error) {
-	Trace("e>", ci)
-	err = ci.CheckValid()
+	return applyToExecutor(ci, p, e, defaultLogger, nil)
+}
+
+// Invalid requests fail with exception 01 for
+// unsupported codes and 03 for anything else.
+// They never reach e and are recorded to metrics
+// when not nil, executed ones are measured by e.
+func applyToExecutor(ci *Command, p Protocol, e Executor, logger Logger, metrics MetricsHook) (co *Command, fbuf []byte, err error) {
+	logCommand(logger, "e", ">", ci)
+	err = validationErr(ci)
 	if err != nil {
+		if metrics != nil {
+			metrics.Command(SideSlave, ci, 0, err)
+		}
 		return
 	}
 	co, err = e.Execute(ci)
 	if err != nil {
 		return
 	}
-	Trace("e<", co)
+	logCommand(logger, "e", "<", co)
 	reslen := ci.ResponseLength()
 	fbuf, buf := p.MakeBuffers(reslen)
 	co.EncodeResponse(buf)
@@ -18,16 +155,20 @@ func ApplyToExecutor(ci *Command, p Protocol, e Executor) (co *Command, fbuf []b
 	return
 }
 
+// Resyncs on framing errors and ends on I/O errors
 func RunSlave(proto Protocol, trans Transport, exec Executor) (err error) {
-	for {
-		err = RunOneSlave(proto, trans, exec)
-		if err != nil {
-			return
-		}
-	}
+	return NewSlave(proto, trans, exec, SlaveOptions{}).Run()
 }
 
+// Logs to the transport logger when set.
+// Scan errors other than I/O match ErrFraming.
 func RunOneSlave(proto Protocol, trans Transport, exec Executor) (err error) {
+	logger := orDefaultLogger(loggerOf(trans))
+	_, err = runOneSlave(proto, trans, exec, logger, nil)
+	return
+}
+
+func runOneSlave(proto Protocol, trans Transport, exec Executor, logger Logger, metrics MetricsHook) (exception bool, err error) {
 	defer func() {
 		if err != nil {
 			trans.DiscardOn()
@@ -36,14 +177,30 @@ func RunOneSlave(proto Protocol, trans Transport, exec Executor) (err error) {
 	trans.DiscardIf()
 	ci, err := proto.Scan(trans)
 	if err != nil {
+		if !isIoErr(err) {
+			err = &framingError{err}
+		}
+		return
+	}
+	_, rbuf, err := applyToExecutor(ci, proto, exec, logger, metrics)
+	if errors.Is(err, ErrNoResponse) {
+		err = nil
+		return
+	}
+	if bp, ok := proto.(broadcaster); ok && bp.broadcast(ci) {
+		err = nil
 		return
 	}
-	_, rbuf, err := ApplyToExecutor(ci, proto, exec)
 	if err != nil {
+		exception = true
 		fbuf, buf := proto.MakeBuffers(3)
 		buf[0] = ci.Slave
 		buf[1] = ci.Code | 0x80
 		buf[2] = ^ci.Code
+		var me *ModbusException
+		if errors.As(err, &me) {
+			buf[2] = me.Code
+		}
 		proto.WrapBuffer(fbuf, 3)
 		rbuf = fbuf
 	}
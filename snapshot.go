@@ -0,0 +1,413 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const SnapshotVersion = 1
+
+// Stable JSON image of a model contents.
+// Slaves and blocks are sorted by number and address,
+// blocks are maximal runs of contiguous addresses.
+type Snapshot struct {
+	Version int              `json:"version"`
+	Slaves  []*SlaveSnapshot `json:"slaves"`
+}
+
+type SlaveSnapshot struct {
+	Slave byte         `json:"slave"`
+	Dos   []*BoolBlock `json:"dos,omitempty"`
+	Dis   []*BoolBlock `json:"dis,omitempty"`
+	Wos   []*WordBlock `json:"wos,omitempty"`
+	Wis   []*WordBlock `json:"wis,omitempty"`
+}
+
+type BoolBlock struct {
+	Address uint16 `json:"address"`
+	Values  []bool `json:"values"`
+}
+
+type WordBlock struct {
+	Address uint16   `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// Implemented by models that can enumerate their contents
+type SnapshotModel interface {
+	Snapshot() (*Snapshot, error)
+}
+
+// Fails if the model cannot enumerate its contents
+func TakeSnapshot(model Model) (*Snapshot, error) {
+	sm, ok := model.(SnapshotModel)
+	if !ok {
+		return nil, formatErr("model unsupported %T", model)
+	}
+	return sm.Snapshot()
+}
+
+// Overwrites the addresses covered by the snapshot atomically.
+// Fails without writing anything when a bounded model does not
+// contain every block of the snapshot.
+func RestoreSnapshot(model Model, snap *Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return formatErr("version mismatch got %d expected %d", snap.Version, SnapshotVersion)
+	}
+	if bm, ok := model.(BoundedModel); ok {
+		for _, ss := range snap.Slaves {
+			for _, b := range ss.Dos {
+				if !containsBlock(bm, ss.Slave, ReadDos01, b.Address, len(b.Values)) {
+					return blockErr(ss.Slave, ReadDos01, b.Address, len(b.Values))
+				}
+			}
+			for _, b := range ss.Dis {
+				if !containsBlock(bm, ss.Slave, ReadDis02, b.Address, len(b.Values)) {
+					return blockErr(ss.Slave, ReadDis02, b.Address, len(b.Values))
+				}
+			}
+			for _, b := range ss.Wos {
+				if !containsBlock(bm, ss.Slave, ReadWos03, b.Address, len(b.Values)) {
+					return blockErr(ss.Slave, ReadWos03, b.Address, len(b.Values))
+				}
+			}
+			for _, b := range ss.Wis {
+				if !containsBlock(bm, ss.Slave, ReadWis04, b.Address, len(b.Values)) {
+					return blockErr(ss.Slave, ReadWis04, b.Address, len(b.Values))
+				}
+			}
+		}
+	}
+	RunAtomic(model, func(m Model) {
+		for _, ss := range snap.Slaves {
+			for _, b := range ss.Dos {
+				m.WriteDos(ss.Slave, b.Address, b.Values...)
+			}
+			for _, b := range ss.Dis {
+				m.WriteDis(ss.Slave, b.Address, b.Values...)
+			}
+			for _, b := range ss.Wos {
+				m.WriteWos(ss.Slave, b.Address, b.Values...)
+			}
+			for _, b := range ss.Wis {
+				m.WriteWis(ss.Slave, b.Address, b.Values...)
+			}
+		}
+	})
+	return nil
+}
+
+// Blocks may span the whole 0x10000 addresses
+// so they are checked in chunks fitting a uint16
+func containsBlock(bm BoundedModel, slave byte, area byte, address uint16, count int) bool {
+	if int(address)+count > 0x10000 {
+		return false
+	}
+	for offset := 0; offset < count; offset += 0x8000 {
+		chunk := count - offset
+		if chunk > 0x8000 {
+			chunk = 0x8000
+		}
+		if !bm.Contains(slave, area, uint16(int(address)+offset), uint16(chunk)) {
+			return false
+		}
+	}
+	return true
+}
+
+func blockErr(slave byte, area byte, address uint16, count int) error {
+	return formatErr("slave %d area %s block %04x:%d not declared", slave, AreaName(area), address, count)
+}
+
+func WriteSnapshot(writer io.Writer, snap *Snapshot) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snap)
+}
+
+func ReadSnapshot(reader io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{}
+	err := json.NewDecoder(reader).Decode(snap)
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Writes to a temporary file in the same directory
+// and renames it over path so readers never see
+// a partially written snapshot
+func SaveSnapshot(model Model, path string) error {
+	snap, err := TakeSnapshot(model)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	err = WriteSnapshot(buf, snap)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+func LoadSnapshot(model Model, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	snap, err := ReadSnapshot(file)
+	if err != nil {
+		return err
+	}
+	return RestoreSnapshot(model, snap)
+}
+
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, name+".tmp*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	cerr := file.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(file.Name(), path)
+	return
+}
+
+// Saves a model to a file periodically while it changes
+type Autosave struct {
+	model  Model
+	path   string
+	mutex  sync.Mutex
+	last   []byte
+	err    error
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// Saves now skipping the write if contents did not change
+func (a *Autosave) Save() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	snap, err := TakeSnapshot(a.model)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	err = WriteSnapshot(buf, snap)
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if a.last != nil && bytes.Equal(a.last, data) {
+		return nil
+	}
+	err = writeFileAtomic(a.path, data)
+	if err != nil {
+		return err
+	}
+	a.last = data
+	return nil
+}
+
+// Last error from a periodic save
+func (a *Autosave) Err() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.err
+}
+
+// Stops the periodic saves and saves one last time
+func (a *Autosave) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mutex.Unlock()
+	close(a.stop)
+	<-a.done
+	return a.Save()
+}
+
+func (a *Autosave) run(interval time.Duration) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			err := a.Save()
+			a.mutex.Lock()
+			a.err = err
+			a.mutex.Unlock()
+		}
+	}
+}
+
+func (m *mapModel) Snapshot() (*Snapshot, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	slaves := make(map[byte]*SlaveSnapshot)
+	get := func(slave byte) *SlaveSnapshot {
+		ss, ok := slaves[slave]
+		if !ok {
+			ss = &SlaveSnapshot{Slave: slave}
+			slaves[slave] = ss
+		}
+		return ss
+	}
+	for slave, addresses := range m.keys(m.dos) {
+		ss := get(slave)
+		ss.Dos = boolBlocks(addresses, func(a uint16) bool { return m.dos[m.Key(slave, int(a))] })
+	}
+	for slave, addresses := range m.keys(m.dis) {
+		ss := get(slave)
+		ss.Dis = boolBlocks(addresses, func(a uint16) bool { return m.dis[m.Key(slave, int(a))] })
+	}
+	for slave, addresses := range m.wordKeys(m.wos) {
+		ss := get(slave)
+		ss.Wos = wordBlocks(addresses, func(a uint16) uint16 { return m.wos[m.Key(slave, int(a))] })
+	}
+	for slave, addresses := range m.wordKeys(m.wis) {
+		ss := get(slave)
+		ss.Wis = wordBlocks(addresses, func(a uint16) uint16 { return m.wis[m.Key(slave, int(a))] })
+	}
+	return sortedSnapshot(slaves), nil
+}
+
+func (m *mapModel) keys(values map[string]bool) map[byte][]uint16 {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return parseKeys(keys)
+}
+
+func (m *mapModel) wordKeys(values map[string]uint16) map[byte][]uint16 {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return parseKeys(keys)
+}
+
+// Inverse of mapModel.Key with sorted addresses
+func parseKeys(keys []string) map[byte][]uint16 {
+	slaves := make(map[byte][]uint16)
+	for _, key := range keys {
+		var slave byte
+		var address uint16
+		_, err := fmt.Sscanf(key, "%d_%x", &slave, &address)
+		if err != nil {
+			continue
+		}
+		slaves[slave] = append(slaves[slave], address)
+	}
+	for _, addresses := range slaves {
+		sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	}
+	return slaves
+}
+
+func (m *denseModel) Snapshot() (*Snapshot, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	slaves := make(map[byte]*SlaveSnapshot)
+	for i, ds := range m.slaves {
+		if ds == nil {
+			continue
+		}
+		ss := &SlaveSnapshot{Slave: byte(i)}
+		if len(ds.dos.values) > 0 {
+			ss.Dos = []*BoolBlock{{uint16(ds.dos.base), copyBools(ds.dos.values)}}
+		}
+		if len(ds.dis.values) > 0 {
+			ss.Dis = []*BoolBlock{{uint16(ds.dis.base), copyBools(ds.dis.values)}}
+		}
+		if len(ds.wos.values) > 0 {
+			ss.Wos = []*WordBlock{{uint16(ds.wos.base), copyWords(ds.wos.values)}}
+		}
+		if len(ds.wis.values) > 0 {
+			ss.Wis = []*WordBlock{{uint16(ds.wis.base), copyWords(ds.wis.values)}}
+		}
+		slaves[byte(i)] = ss
+	}
+	return sortedSnapshot(slaves), nil
+}
+
+func (m *observableModel) Snapshot() (*Snapshot, error) {
+	return TakeSnapshot(m.model)
+}
+
+func (m *lockedModel) Snapshot() (*Snapshot, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return TakeSnapshot(m.model)
+}
+
+func sortedSnapshot(slaves map[byte]*SlaveSnapshot) *Snapshot {
+	snap := &Snapshot{Version: SnapshotVersion}
+	snap.Slaves = make([]*SlaveSnapshot, 0, len(slaves))
+	for i := 0; i < 256; i++ {
+		if ss, ok := slaves[byte(i)]; ok {
+			snap.Slaves = append(snap.Slaves, ss)
+		}
+	}
+	return snap
+}
+
+// Addresses must be sorted
+func boolBlocks(addresses []uint16, value func(a uint16) bool) (blocks []*BoolBlock) {
+	var block *BoolBlock
+	for _, a := range addresses {
+		if block == nil || int(block.Address)+len(block.Values) != int(a) {
+			block = &BoolBlock{Address: a}
+			blocks = append(blocks, block)
+		}
+		block.Values = append(block.Values, value(a))
+	}
+	return
+}
+
+// Addresses must be sorted
+func wordBlocks(addresses []uint16, value func(a uint16) uint16) (blocks []*WordBlock) {
+	var block *WordBlock
+	for _, a := range addresses {
+		if block == nil || int(block.Address)+len(block.Values) != int(a) {
+			block = &WordBlock{Address: a}
+			blocks = append(blocks, block)
+		}
+		block.Values = append(block.Values, value(a))
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package spec
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
@@ -39,6 +40,10 @@ type SetupProtoTest struct {
 }
 
 func setupMasterSlave(t *testing.T, proto modbus.Protocol, cb func(s *SetupProtoTest)) {
+	setupMasterSlaveModel(t, proto, modbus.NewMapModel(), cb)
+}
+
+func setupMasterSlaveModel(t *testing.T, proto modbus.Protocol, model modbus.Model, cb func(s *SetupProtoTest)) {
 	listen, err := net.Listen("tcp", ":0")
 	fatalIfError(t, err)
 	defer listen.Close()
@@ -46,7 +51,7 @@ func setupMasterSlave(t *testing.T, proto modbus.Protocol, cb func(s *SetupProto
 	setup.T = t
 	setup.Proto = proto
 	port := listen.Addr().(*net.TCPAddr).Port
-	setup.Model = modbus.NewMapModel()
+	setup.Model = model
 	exec := modbus.NewModelExecutor(setup.Model)
 	execw := &ExceptionExecutor{exec}
 	go func() {
@@ -67,6 +72,211 @@ func setupMasterSlave(t *testing.T, proto modbus.Protocol, cb func(s *SetupProto
 	cb(setup)
 }
 
+//FAULTS////////////////////////////
+
+// Injects every fault in the responses read by the master
+// and in the requests read by the slave, for reads and writes,
+// and checks the commands that follow recover through
+// DiscardOn and DiscardIf. Master and slave get their
+// own protocol instance from factory.
+func FaultTest(t *testing.T, factory func() modbus.Protocol) {
+	log.Println("faults", reflect.TypeOf(factory()))
+	faultMasterTest(t, factory)
+	faultSlaveTest(t, factory)
+}
+
+// Implemented by the fault transport
+type faultInjector interface {
+	modbus.Transport
+	Inject(faults ...modbus.Fault)
+	Injected() []modbus.Fault
+}
+
+var faultList = []modbus.Fault{
+	modbus.FaultNone,
+	modbus.FaultDelay,
+	modbus.FaultDrop,
+	modbus.FaultFlip,
+	modbus.FaultSplit,
+	modbus.FaultGarbage,
+	modbus.FaultDuplicate,
+}
+
+func faultMasterTest(t *testing.T, factory func() modbus.Protocol) {
+	listen, err := net.Listen("tcp", ":0")
+	fatalIfError(t, err)
+	defer listen.Close()
+	model := modbus.NewMapModel()
+	exec := modbus.NewModelExecutor(model)
+	go func() {
+		input, err := listen.Accept()
+		if err != nil {
+			return
+		}
+		defer input.Close()
+		modbus.RunSlave(factory(), modbus.NewConnTransport(input), exec)
+	}()
+	otrans, err := modbus.NewTcpTransport(listen.Addr().String(), 400)
+	fatalIfError(t, err)
+	trans := modbus.NewFaultTransport(otrans, modbus.FaultOptions{})
+	master := modbus.NewMaster(factory(), trans, 200)
+	defer master.Close()
+	for _, fault := range faultList {
+		model.WriteWos(1, 0, 1, 2, 3, 4)
+		trans.Inject(fault)
+		words, err := master.ReadWos(1, 0, 4)
+		switch fault {
+		case modbus.FaultNone, modbus.FaultDelay, modbus.FaultDuplicate:
+			assertWordsEqualErr(t, err, words, []uint16{1, 2, 3, 4})
+		case modbus.FaultDrop, modbus.FaultSplit, modbus.FaultGarbage:
+			if err == nil {
+				t.Fatalf("%s error expected", fault)
+			}
+		case modbus.FaultFlip:
+			//flipped data bits pass protocols without checksum
+			if err == nil && wordsEqual(words, []uint16{1, 2, 3, 4}) {
+				t.Fatalf("%s error or mismatch expected", fault)
+			}
+		}
+		testRecovery(t, fault, model, master)
+		model.WriteWos(1, 20, 0, 0)
+		trans.Inject(fault)
+		err = master.WriteWos(1, 20, 5, 6)
+		switch fault {
+		case modbus.FaultNone, modbus.FaultDelay, modbus.FaultDuplicate:
+			fatalIfError(t, err)
+		case modbus.FaultDrop, modbus.FaultSplit, modbus.FaultGarbage:
+			if err == nil {
+				t.Fatalf("%s error expected", fault)
+			}
+		}
+		//response faults happen after the write applied
+		assertWordsEqualErr(t, nil, model.ReadWos(1, 20, 2), []uint16{5, 6})
+		testRecovery(t, fault, model, master)
+	}
+	trans.Inject(modbus.FaultClose)
+	_, err = master.ReadWos(1, 0, 4)
+	if err == nil {
+		t.Fatalf("close error expected")
+	}
+	err = master.WriteWo(1, 0, 0)
+	if err == nil {
+		t.Fatalf("closed error expected")
+	}
+	//none is not recorded but close is
+	expected := 2*(len(faultList)-1) + 1
+	if len(trans.Injected()) != expected {
+		t.Fatalf("injected mismatch got %d expected %d", len(trans.Injected()), expected)
+	}
+}
+
+// Faults hit the requests the slave scans, the slave
+// resyncs on framing errors and ends on close letting
+// the next connection be served
+func faultSlaveTest(t *testing.T, factory func() modbus.Protocol) {
+	listen, err := net.Listen("tcp", ":0")
+	fatalIfError(t, err)
+	defer listen.Close()
+	model := modbus.NewMapModel()
+	exec := modbus.NewModelExecutor(model)
+	injectors := make(chan faultInjector, 1)
+	slaves := make(chan *modbus.Slave, 1)
+	done := make(chan error, 2)
+	go func() {
+		for {
+			input, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			trans := modbus.NewFaultTransport(modbus.NewConnTransport(input), modbus.FaultOptions{})
+			slave := modbus.NewSlave(factory(), trans, exec, modbus.SlaveOptions{})
+			injectors <- trans
+			slaves <- slave
+			done <- slave.Run()
+			input.Close()
+		}
+	}()
+	dial := func() modbus.CloseableMaster {
+		otrans, err := modbus.NewTcpTransport(listen.Addr().String(), 400)
+		fatalIfError(t, err)
+		return modbus.NewMaster(factory(), otrans, 200)
+	}
+	master := dial()
+	defer master.Close()
+	trans := <-injectors
+	slave := <-slaves
+	for _, fault := range faultList {
+		model.WriteWos(1, 0, 1, 2, 3, 4)
+		trans.Inject(fault)
+		words, err := master.ReadWos(1, 0, 4)
+		switch fault {
+		case modbus.FaultNone, modbus.FaultDelay:
+			assertWordsEqualErr(t, err, words, []uint16{1, 2, 3, 4})
+		case modbus.FaultDrop, modbus.FaultSplit:
+			if err == nil {
+				t.Fatalf("slave %s error expected", fault)
+			}
+		}
+		testRecovery(t, fault, model, master)
+		model.WriteWos(1, 20, 0, 0)
+		trans.Inject(fault)
+		err = master.WriteWos(1, 20, 5, 6)
+		switch fault {
+		case modbus.FaultNone, modbus.FaultDelay:
+			fatalIfError(t, err)
+			assertWordsEqualErr(t, nil, model.ReadWos(1, 20, 2), []uint16{5, 6})
+		case modbus.FaultDrop, modbus.FaultSplit:
+			if err == nil {
+				t.Fatalf("slave %s error expected", fault)
+			}
+			//request faults happen before the write applies
+			assertWordsEqualErr(t, nil, model.ReadWos(1, 20, 2), []uint16{0, 0})
+		}
+		testRecovery(t, fault, model, master)
+	}
+	if slave.Stats().Framing == 0 {
+		t.Fatalf("slave framing errors expected")
+	}
+	trans.Inject(modbus.FaultClose)
+	err = master.WriteWo(1, 0, 0)
+	if err == nil {
+		t.Fatalf("slave close error expected")
+	}
+	if err := <-done; err == nil {
+		t.Fatalf("slave run error expected")
+	}
+	master.Close()
+	master = dial()
+	<-injectors
+	<-slaves
+	testRecovery(t, modbus.FaultClose, model, master)
+}
+
+// A stale response may fail the first commands
+// but three round trips in a row must succeed
+func testRecovery(t *testing.T, fault modbus.Fault, model modbus.Model, master modbus.Master) {
+	ok := 0
+	for attempt := 0; attempt < 6 && ok < 3; attempt++ {
+		value := randWord()
+		err := master.WriteWo(1, 10, value)
+		if err == nil {
+			var word uint16
+			word, err = master.ReadWo(1, 10)
+			if err == nil && word != value {
+				err = formatErr("value mismatch got %04x expected %04x", word, value)
+			}
+		}
+		if err != nil {
+			ok = 0
+			continue
+		}
+		ok++
+	}
+	if ok < 3 {
+		t.Fatalf("%s recovery failed", fault)
+	}
+}
+
 //MASTER////////////////////////////
 
 func ModelMasterTest(t *testing.T, model modbus.Model, master modbus.Master) {
@@ -322,6 +532,34 @@ func assertWordsEqualErr(t *testing.T, err error, a, b []uint16) {
 	assertWordsEqual(t, a, b)
 }
 
+// Blocks of a pcapng stream, header and interface first
+func pcapBlocks(data []byte) [][]byte {
+	blocks := [][]byte{}
+	for len(data) > 0 {
+		total := int(binary.LittleEndian.Uint32(data[4:8]))
+		blocks = append(blocks, data[:total])
+		data = data[total:]
+	}
+	return blocks
+}
+
+// Captured bytes of an enhanced packet block
+func pcapPacket(block []byte) []byte {
+	return block[28 : 28+binary.LittleEndian.Uint32(block[20:24])]
+}
+
+func wordsEqual(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func assertWordsEqual(t *testing.T, a, b []uint16) {
 	if len(a) != len(b) {
 		t.Fatalf("len mismatch %d %d", len(a), len(b))
@@ -1,8 +1,22 @@
 package spec
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/samuelventura/go-modbus"
 )
@@ -14,3 +28,1120 @@ func TestModbus(t *testing.T) {
 	setupMasterSlave(t, modbus.NewRtuProtocol(), ProtocolTest)
 	setupMasterSlave(t, modbus.NewTcpProtocol(), ProtocolTest)
 }
+
+func TestFaults(t *testing.T) {
+	FaultTest(t, modbus.NewNopProtocol)
+	FaultTest(t, modbus.NewRtuProtocol)
+	FaultTest(t, modbus.NewTcpProtocol)
+}
+
+func TestLogger(t *testing.T) {
+	logs := make([][]*modbus.LogEntry, 2)
+	for i := range logs {
+		index := i
+		logger := modbus.NewFuncLogger(func(entry *modbus.LogEntry) {
+			logs[index] = append(logs[index], entry)
+		})
+		mconn, sconn := net.Pipe()
+		strans := modbus.NewConnTransport(sconn)
+		exec := modbus.NewModelExecutor(modbus.NewMapModel())
+		go modbus.RunSlave(modbus.NewTcpProtocol(), strans, exec)
+		master := modbus.NewTcpMaster(modbus.NewConnTransport(mconn), 400)
+		master.(modbus.Loggable).SetLogger(logger)
+		fatalIfError(t, master.WriteWo(byte(i+1), 2, 0x1234))
+		master.Close()
+	}
+	for i, entries := range logs {
+		//command and frame each way logged once
+		if len(entries) != 4 {
+			t.Fatalf("entries mismatch got %d expected %d", len(entries), 4)
+		}
+		for _, entry := range entries {
+			slave, ok := entry.Slave()
+			if ok && slave != byte(i+1) {
+				t.Fatalf("slave mismatch got %d expected %d", slave, i+1)
+			}
+		}
+		line := entries[1].String()
+		frame := fmt.Sprintf("t> unit=%d func=WriteWo06 frame=[00 00 00 00 00 06 %02x 06 00 02 12 34]", i+1, i+1)
+		if !strings.Contains(line, frame) {
+			t.Fatalf("frame mismatch got %s", line)
+		}
+	}
+}
+
+func TestPcap(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mconn, sconn := net.Pipe()
+	exec := modbus.NewModelExecutor(modbus.NewMapModel())
+	go modbus.RunSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), exec)
+	trans, err := modbus.NewPcapTcpTransport(modbus.NewConnTransport(mconn), buf, true)
+	fatalIfError(t, err)
+	master := modbus.NewTcpMaster(trans, 400)
+	registry := modbus.NewMetricsRegistry()
+	master.(modbus.Measurable).SetMetrics(registry.Device("plc"))
+	fatalIfError(t, master.WriteWo(1, 2, 0x1234))
+	master.Close()
+	blocks := pcapBlocks(buf.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("blocks mismatch got %d expected %d", len(blocks), 4)
+	}
+	if link := binary.LittleEndian.Uint16(blocks[1][8:10]); link != modbus.PcapLinkEthernet {
+		t.Fatalf("link mismatch got %d expected %d", link, modbus.PcapLinkEthernet)
+	}
+	frame := []byte{0, 0, 0, 0, 0, 6, 1, 6, 0, 2, 0x12, 0x34}
+	for i, block := range blocks[2:] {
+		packet := pcapPacket(block)
+		if !bytes.Equal(packet[54:], frame) {
+			t.Fatalf("payload mismatch got %x expected %x", packet[54:], frame)
+		}
+		//request to the server port then response from it
+		port := binary.BigEndian.Uint16(packet[36:38])
+		if i == 1 {
+			port = binary.BigEndian.Uint16(packet[34:36])
+		}
+		if port != modbus.PcapTcpPort {
+			t.Fatalf("packet %d direction mismatch port %d", i, port)
+		}
+	}
+	text := &bytes.Buffer{}
+	fatalIfError(t, registry.Write(text))
+	if !strings.Contains(text.String(), `modbus_bytes_total{device="plc",direction="out"} 12`) {
+		t.Fatalf("metrics not forwarded\n%s", text.String())
+	}
+	buf.Reset()
+	mconn, sconn = net.Pipe()
+	go modbus.RunSlave(modbus.NewRtuProtocol(), modbus.NewConnTransport(sconn), exec)
+	trans, err = modbus.NewPcapRtuTransport(modbus.NewConnTransport(mconn), buf)
+	fatalIfError(t, err)
+	master = modbus.NewRtuMaster(trans, 400)
+	fatalIfError(t, master.WriteWo(1, 2, 0x1234))
+	master.Close()
+	blocks = pcapBlocks(buf.Bytes())
+	if link := binary.LittleEndian.Uint16(blocks[1][8:10]); link != modbus.PcapLinkRtu {
+		t.Fatalf("link mismatch got %d expected %d", link, modbus.PcapLinkRtu)
+	}
+	proto := modbus.NewRtuProtocol()
+	request, pdu := proto.MakeBuffers(6)
+	copy(pdu, []byte{1, 6, 0, 2, 0x12, 0x34})
+	proto.WrapBuffer(request, 6)
+	if packet := pcapPacket(blocks[2]); !bytes.Equal(packet, request) {
+		t.Fatalf("payload mismatch got %x expected %x", packet, request)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	model := modbus.NewMapModel()
+	model.WriteWis(1, 10, 0x1111, 0x2222)
+	exec := &ExceptionExecutor{modbus.NewModelExecutor(model)}
+	dconn, rconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(dconn), exec)
+	rtrans := modbus.NewConnTransport(rconn)
+	rexec := modbus.NewTransportExecutor(modbus.NewTcpProtocol(), rtrans, 400)
+	recorder := modbus.NewCloseableMaster(modbus.NewRecordExecutor(rexec, buf), rtrans)
+	defer recorder.Close()
+	fatalIfError(t, recorder.WriteWo(1, 2, 0x1234))
+	words, err := recorder.ReadWis(1, 10, 2)
+	assertWordsEqualErr(t, err, words, []uint16{0x1111, 0x2222})
+	err = recorder.WriteDo(0xFF, 0xFFFF, true)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != ^modbus.WriteDo05 {
+		t.Fatalf("exception expected: %v", err)
+	}
+	txs, err := modbus.ReadTransactions(buf)
+	fatalIfError(t, err)
+	if len(txs) != 3 {
+		t.Fatalf("transactions mismatch got %d expected %d", len(txs), 3)
+	}
+	replay := func(master modbus.Master) {
+		fatalIfError(t, master.WriteWo(1, 2, 0x1234))
+		words, err := master.ReadWis(1, 10, 2)
+		assertWordsEqualErr(t, err, words, []uint16{0x1111, 0x2222})
+		err = master.WriteDo(0xFF, 0xFFFF, true)
+		if me, ok := err.(*modbus.ModbusException); !ok || me.Code != ^modbus.WriteDo05 {
+			t.Fatalf("exception expected: %v", err)
+		}
+		_, err = master.ReadWis(1, 11, 2)
+		if err == nil {
+			t.Fatalf("error expected")
+		}
+	}
+	replay(modbus.NewCloseableMaster(modbus.NewReplayExecutor(txs, false), nil))
+	mconn, sconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewRtuProtocol(), modbus.NewConnTransport(sconn), modbus.NewReplayExecutor(txs, false))
+	master := modbus.NewRtuMaster(modbus.NewConnTransport(mconn), 400)
+	defer master.Close()
+	replay(master)
+	//same session recorded at the transport level
+	tbuf := &bytes.Buffer{}
+	dconn, rconn = net.Pipe()
+	go modbus.RunSlave(modbus.NewRtuProtocol(), modbus.NewConnTransport(dconn), exec)
+	rtrans, err = modbus.NewRecordTransport(modbus.NewConnTransport(rconn), tbuf, modbus.FramingRtu)
+	fatalIfError(t, err)
+	trecorder := modbus.NewRtuMaster(rtrans, 400)
+	defer trecorder.Close()
+	fatalIfError(t, trecorder.WriteWo(1, 2, 0x1234))
+	words, err = trecorder.ReadWis(1, 10, 2)
+	assertWordsEqualErr(t, err, words, []uint16{0x1111, 0x2222})
+	err = trecorder.WriteDo(0xFF, 0xFFFF, true)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != ^modbus.WriteDo05 {
+		t.Fatalf("exception expected: %v", err)
+	}
+	ttxs, err := modbus.ReadTransactions(tbuf)
+	fatalIfError(t, err)
+	if len(ttxs) != 3 || ttxs[0].RequestFrame == "" || ttxs[0].Request != txs[0].Request {
+		t.Fatalf("transport transactions mismatch %v", ttxs)
+	}
+	replay(modbus.NewCloseableMaster(modbus.NewReplayExecutor(ttxs, false), nil))
+}
+
+type scanExecutor struct {
+	exec     modbus.Executor
+	requests map[byte]int
+}
+
+func (e *scanExecutor) Execute(ci *modbus.Command) (*modbus.Command, error) {
+	e.requests[ci.Slave]++
+	switch ci.Slave {
+	case 3:
+		if ci.Code == modbus.ReadWos03 && ci.Address >= 100 && int(ci.Address)+int(ci.Corv) <= 150 {
+			return e.exec.Execute(ci)
+		}
+		return nil, &modbus.ModbusException{Code: 2}
+	case 5:
+		return e.exec.Execute(ci)
+	case 7:
+		return nil, errors.New("crc mismatch")
+	case 9:
+		return nil, io.EOF
+	default:
+		return nil, modbus.ErrNoResponse
+	}
+}
+
+func TestScanner(t *testing.T) {
+	exec := &scanExecutor{modbus.NewModelExecutor(modbus.NewMapModel()), map[byte]int{}}
+	master := modbus.NewCloseableMaster(exec, nil)
+	opts := modbus.ScanOptions{End: 0x3FF, Areas: []byte{modbus.ReadWos03}, Units: []byte{1, 3, 5, 7}}
+	dm, err := modbus.NewScanner(master, opts).Scan(context.Background())
+	fatalIfError(t, err)
+	if len(dm.Units) != 3 {
+		t.Fatalf("units mismatch got %d expected %d", len(dm.Units), 3)
+	}
+	if u7 := dm.Units[2]; u7.Unit != 7 || u7.Status != "framing" || len(u7.Areas) != 0 {
+		t.Fatalf("unit mismatch got %d %s %d", u7.Unit, u7.Status, len(u7.Areas))
+	}
+	u3 := dm.Units[0]
+	if u3.Unit != 3 || u3.Status != "exception" || u3.Exception != 2 {
+		t.Fatalf("unit mismatch got %d %s %d", u3.Unit, u3.Status, u3.Exception)
+	}
+	ranges := u3.Areas[0].Ranges
+	if len(ranges) != 1 || ranges[0].Start != 100 || ranges[0].Count != 50 {
+		t.Fatalf("ranges mismatch got %v", ranges)
+	}
+	ranges = dm.Units[1].Areas[0].Ranges
+	if len(ranges) != 1 || ranges[0].Start != 0 || ranges[0].Count != 0x400 {
+		t.Fatalf("ranges mismatch got %v", ranges)
+	}
+	//probe, 2 edge blocks and 7 unmapped ones
+	if exec.requests[3] > 1+2*15+7*3 || exec.requests[5] != 1+9 || exec.requests[7] != 1 {
+		t.Fatalf("requests mismatch got %v", exec.requests)
+	}
+	//a dead link ends the scan at the first unit
+	opts.Units = []byte{9, 10, 11}
+	_, err = modbus.NewScanner(master, opts).Scan(context.Background())
+	if !errors.Is(err, io.EOF) || exec.requests[10] != 0 {
+		t.Fatalf("io error expected: %v %v", err, exec.requests)
+	}
+}
+
+type latchExecutor struct {
+	exec modbus.Executor
+}
+
+func (e *latchExecutor) Execute(ci *modbus.Command) (*modbus.Command, error) {
+	if ci.Code == modbus.WriteWo06 && ci.Address >= 7 {
+		ci.Corv = 0
+	}
+	return e.exec.Execute(ci)
+}
+
+func TestVerifyMaster(t *testing.T) {
+	exec := &latchExecutor{modbus.NewModelExecutor(modbus.NewMapModel())}
+	opts := modbus.VerifyOptions{Retries: 1, Skip: func(slave byte, code byte, address uint16) bool {
+		return address == 8
+	}}
+	master := modbus.NewVerifyMaster(modbus.NewCloseableMaster(exec, nil), opts)
+	fatalIfError(t, master.WriteWo(1, 6, 0x1234))
+	fatalIfError(t, master.WriteWos(1, 5, 0x1234, 0x5678))
+	fatalIfError(t, master.WriteDos(1, 5, true, false, true))
+	err := master.WriteWo(1, 7, 0x1234)
+	if _, ok := err.(*modbus.VerifyError); !ok {
+		t.Fatalf("verify error expected: %v", err)
+	}
+	fatalIfError(t, master.WriteWo(1, 8, 0x1234))
+	//logger and metrics reach the inner master
+	mconn, sconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), modbus.NewModelExecutor(modbus.NewMapModel()))
+	inner := modbus.NewTcpMaster(modbus.NewConnTransport(mconn), 400)
+	master = modbus.NewVerifyMaster(inner, modbus.VerifyOptions{})
+	defer master.Close()
+	entries := 0
+	master.(modbus.Loggable).SetLogger(modbus.NewFuncLogger(func(entry *modbus.LogEntry) { entries++ }))
+	registry := modbus.NewMetricsRegistry()
+	master.(modbus.Measurable).SetMetrics(registry.Device("plc"))
+	fatalIfError(t, master.WriteWo(1, 2, 0x1234))
+	if entries == 0 || master.(modbus.Loggable).Logger() == nil {
+		t.Fatalf("logger not forwarded")
+	}
+	out := &bytes.Buffer{}
+	fatalIfError(t, registry.Write(out))
+	if !strings.Contains(out.String(), `modbus_requests_total{device="plc",side="master",unit="1",function="ReadWos03"} 1`) {
+		t.Fatalf("metrics not forwarded %s", out.String())
+	}
+}
+
+func TestServer(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfError(t, err)
+	exec := modbus.NewModelExecutor(modbus.NewMapModel())
+	registry := modbus.NewMetricsRegistry()
+	server := modbus.NewTcpServer(exec, modbus.ServerOptions{MaxConns: 2, Metrics: registry.Device("server")})
+	done := make(chan error)
+	go func() { done <- server.Serve(listen) }()
+	address := listen.Addr().String()
+	masters := []modbus.CloseableMaster{}
+	for i := 0; i < 3; i++ {
+		trans, err := modbus.NewTcpTransport(address, 400)
+		fatalIfError(t, err)
+		master := modbus.NewTcpMaster(trans, 400)
+		defer master.Close()
+		masters = append(masters, master)
+	}
+	var wg sync.WaitGroup
+	for i, master := range masters[:2] {
+		wg.Add(1)
+		go func(slave byte, master modbus.Master) {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				err := master.WriteWo(slave, uint16(k), uint16(k))
+				if err == nil {
+					_, err = master.ReadWo(slave, uint16(k))
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(byte(i+1), master)
+	}
+	wg.Wait()
+	if err := masters[2].WriteWo(3, 0, 0); err == nil {
+		t.Fatalf("error expected over max conns")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fatalIfError(t, server.Shutdown(ctx))
+	if err := <-done; err != modbus.ErrServerClosed {
+		t.Fatalf("server closed expected: %v", err)
+	}
+	if err := masters[0].WriteWo(1, 0, 0); err == nil {
+		t.Fatalf("error expected after shutdown")
+	}
+	buf := &bytes.Buffer{}
+	fatalIfError(t, registry.Write(buf))
+	for _, line := range []string{
+		`modbus_requests_total{device="server",side="slave",unit="1",function="WriteWo06"} 100`,
+		`modbus_requests_total{device="server",side="slave",unit="2",function="ReadWos03"} 100`,
+		`modbus_framing_errors_total{device="server"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("line missing %s\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	model1 := modbus.NewMapModel()
+	model2 := modbus.NewMapModel()
+	router := modbus.NewTcpRouter()
+	router.RouteModel(1, model1)
+	router.RouteModel(2, model2)
+	master := modbus.NewCloseableMaster(router, nil)
+	fatalIfError(t, master.WriteWo(1, 0, 0x1111))
+	fatalIfError(t, master.WriteWo(2, 0, 0x2222))
+	assertWordsEqual(t, model1.ReadWos(1, 0, 1), []uint16{0x1111})
+	assertWordsEqual(t, model2.ReadWos(2, 0, 1), []uint16{0x2222})
+	err := master.WriteWo(3, 0, 0)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExGatewayTarget0B {
+		t.Fatalf("exception expected: %v", err)
+	}
+	rtu := modbus.NewRtuRouter()
+	err = modbus.NewCloseableMaster(rtu, nil).WriteWo(3, 0, 0)
+	if !errors.Is(err, modbus.ErrNoResponse) {
+		t.Fatalf("no response expected: %v", err)
+	}
+	rtu.Default(modbus.NewModelExecutor(model1))
+	fatalIfError(t, modbus.NewCloseableMaster(rtu, nil).WriteWo(3, 0, 0x3333))
+	assertWordsEqual(t, model1.ReadWos(3, 0, 1), []uint16{0x3333})
+}
+
+func TestAtomicModel(t *testing.T) {
+	model := modbus.NewMapModel()
+	model.WriteWos(1, 0, 0x0000, 0xFFFF)
+	master := modbus.NewCloseableMaster(modbus.NewModelExecutor(model), nil)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for k := 0; k < 1000; k++ {
+			value := uint16(k)
+			if err := master.WriteWos(1, 0, value, ^value); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for k := 0; k < 1000; k++ {
+			modbus.RunAtomic(model, func(m modbus.Model) {
+				words := m.ReadWos(1, 0, 2)
+				m.WriteWos(1, 0, words[0]+1, ^(words[0] + 1))
+			})
+		}
+	}()
+	for k := 0; k < 1000; k++ {
+		words, err := master.ReadWos(1, 0, 2)
+		fatalIfError(t, err)
+		if words[0] != ^words[1] {
+			t.Fatalf("half update %04x %04x", words[0], words[1])
+		}
+	}
+	wg.Wait()
+}
+
+func TestDenseModel(t *testing.T) {
+	model := modbus.NewDenseModel()
+	for ss := 0; ss < 0x1FF; ss += 50 {
+		for _, area := range modbus.ScanAreas {
+			fatalIfError(t, model.Declare(byte(ss), area, 0, 0x10000))
+		}
+	}
+	setupMasterSlaveModel(t, modbus.NewTcpProtocol(), model, ProtocolTest)
+	fatalIfError(t, model.Declare(1, modbus.ReadWos03, 100, 10))
+	master := modbus.NewCloseableMaster(modbus.NewModelExecutor(model), nil)
+	fatalIfError(t, master.WriteWos(1, 108, 1, 2))
+	err := master.WriteWos(1, 109, 1, 2)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExIllegalAddress02 {
+		t.Fatalf("exception expected: %v", err)
+	}
+	_, err = master.ReadWis(1, 0, 1)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExIllegalAddress02 {
+		t.Fatalf("exception expected: %v", err)
+	}
+}
+
+func BenchmarkMapModelReadWos(b *testing.B) {
+	benchmarkModel(b, modbus.NewMapModel(), false)
+}
+
+func BenchmarkDenseModelReadWos(b *testing.B) {
+	benchmarkModel(b, denseBenchModel(b), false)
+}
+
+func BenchmarkMapModelWriteWos(b *testing.B) {
+	benchmarkModel(b, modbus.NewMapModel(), true)
+}
+
+func BenchmarkDenseModelWriteWos(b *testing.B) {
+	benchmarkModel(b, denseBenchModel(b), true)
+}
+
+func denseBenchModel(b *testing.B) modbus.Model {
+	model := modbus.NewDenseModel()
+	if err := model.Declare(1, modbus.ReadWos03, 0, 0x10000); err != nil {
+		b.Fatal(err)
+	}
+	return model
+}
+
+// Full 125 register requests walking the address space
+func benchmarkModel(b *testing.B, model modbus.Model, write bool) {
+	words := randWords(125)
+	model.WriteWos(1, 0, words...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		address := uint16(i*125) % 0xFF00
+		if write {
+			model.WriteWos(1, address, words...)
+		} else {
+			model.ReadWos(1, address, 125)
+		}
+	}
+}
+
+func TestObservableModel(t *testing.T) {
+	model := modbus.NewObservableModel(modbus.NewMapModel())
+	changes := make(chan *modbus.Change, 10)
+	model.SubscribeChan(modbus.ModelRange{Slave: 1, Area: modbus.ReadWos03, Address: 10, Count: 2}, changes)
+	model.Veto(modbus.ModelRange{Slave: 1, Area: modbus.ReadDos01}, func(c *modbus.Change) error {
+		index := 5 - int(c.Address)
+		if index >= 0 && index < len(c.NewBools) && c.NewBools[index] && !c.OldBools[index] {
+			return &modbus.ModbusException{Code: modbus.ExIllegalValue03}
+		}
+		return nil
+	})
+	master := modbus.NewCloseableMaster(modbus.NewModelExecutor(model), nil)
+	fatalIfError(t, master.WriteWos(1, 9, 1, 2, 3))
+	fatalIfError(t, master.WriteWo(1, 12, 4))
+	model.WriteWos(1, 11, 5)
+	c := <-changes
+	assertWordsEqual(t, c.OldWords, []uint16{0, 0, 0})
+	assertWordsEqual(t, c.NewWords, []uint16{1, 2, 3})
+	c = <-changes
+	assertWordsEqual(t, c.OldWords, []uint16{3})
+	assertWordsEqual(t, c.NewWords, []uint16{5})
+	if len(changes) != 0 {
+		t.Fatalf("changes mismatch got %d expected %d", len(changes), 0)
+	}
+	fatalIfError(t, master.WriteDo(1, 4, true))
+	err := master.WriteDos(1, 4, true, true)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExIllegalValue03 {
+		t.Fatalf("exception expected: %v", err)
+	}
+	assertBoolsEqual(t, model.ReadDos(1, 4, 2), []bool{true, false})
+	model.Veto(modbus.ModelRange{Slave: 2, Area: modbus.ReadDos01, Address: 20, Count: 1}, func(c *modbus.Change) error {
+		if c.OldBools[0] {
+			return &modbus.ModbusException{Code: modbus.ExBusy06}
+		}
+		return nil
+	})
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if master.WriteDo(2, 20, true) == nil {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Fatalf("accepted mismatch got %d expected %d", accepted, 1)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	model := modbus.NewMapModel()
+	model.WriteWos(2, 10, 1, 2, 3)
+	model.WriteWos(2, 20, 4)
+	model.WriteDis(1, 0, true, false)
+	autosave, err := modbus.StartAutosave(model, path, time.Hour)
+	fatalIfError(t, err)
+	fatalIfError(t, autosave.Close())
+	dense := modbus.NewDenseModel()
+	fatalIfError(t, dense.Declare(2, modbus.ReadWos03, 0, 32))
+	err = modbus.LoadSnapshot(dense, path)
+	if err == nil {
+		t.Fatalf("undeclared block error expected")
+	}
+	assertWordsEqual(t, dense.ReadWos(2, 10, 3), []uint16{0, 0, 0})
+	fatalIfError(t, dense.Declare(1, modbus.ReadDis02, 0, 8))
+	fatalIfError(t, modbus.LoadSnapshot(dense, path))
+	assertWordsEqual(t, dense.ReadWos(2, 10, 3), []uint16{1, 2, 3})
+	assertWordsEqual(t, dense.ReadWos(2, 20, 1), []uint16{4})
+	assertBoolsEqual(t, dense.ReadDis(1, 0, 2), []bool{true, false})
+	snap, err := modbus.TakeSnapshot(model)
+	fatalIfError(t, err)
+	if len(snap.Slaves) != 2 || len(snap.Slaves[1].Wos) != 2 || snap.Slaves[1].Wos[1].Address != 20 {
+		t.Fatalf("snapshot mismatch")
+	}
+	_, err = modbus.StartAutosave(model, path, 0)
+	if err == nil {
+		t.Fatalf("interval error expected")
+	}
+	locked := modbus.NewLockedModel(struct{ modbus.Model }{modbus.NewMapModel()})
+	_, err = modbus.TakeSnapshot(locked)
+	if err == nil {
+		t.Fatalf("unsupported model error expected")
+	}
+	_, err = modbus.StartAutosave(locked, path, time.Hour)
+	if err == nil {
+		t.Fatalf("unsupported model error expected")
+	}
+}
+
+func TestRegisterMap(t *testing.T) {
+	csv := `area,address,type,byte order,initial value,read-only,description
+hr,0,f32,CDAB,1.5,,setpoint
+hr,2,i32,ABCD,-2,x,counter
+hr,10,string,,AB3,,name
+coil,5,,,1,,motor
+`
+	yaml := `
+registers:
+  - {slave: 2, area: ir, address: 1, type: u16, value: 0x1234}
+  - {slave: 2, area: di, address: 0, value: true}
+`
+	check := func(rm *modbus.RegisterMap, err error) modbus.Master {
+		fatalIfError(t, err)
+		model, err := modbus.NewRegisterModel(rm)
+		fatalIfError(t, err)
+		return modbus.NewCloseableMaster(modbus.NewModelExecutor(model), nil)
+	}
+	master := check(modbus.ReadRegisterMapCsv(strings.NewReader(csv)))
+	words, err := master.ReadWos(1, 0, 4)
+	assertWordsEqualErr(t, err, words, []uint16{0x0000, 0x3FC0, 0xFFFF, 0xFFFE})
+	value, err := modbus.DecodeValue(modbus.TypeF32, modbus.OrderCDAB, words[:2])
+	fatalIfError(t, err)
+	if value.(float64) != 1.5 {
+		t.Fatalf("value mismatch got %v", value)
+	}
+	words, err = master.ReadWos(1, 10, 2)
+	assertWordsEqualErr(t, err, words, []uint16{0x4142, 0x3300})
+	bool1, err := master.ReadDo(1, 5)
+	assertBoolEqualErr(t, err, bool1, true)
+	fatalIfError(t, master.WriteWo(1, 0, 1))
+	expect02 := func(err error) {
+		t.Helper()
+		if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExIllegalAddress02 {
+			t.Fatalf("exception expected: %v", err)
+		}
+	}
+	expect02(master.WriteWo(1, 3, 1))
+	_, err = master.ReadWos(1, 3, 2)
+	expect02(err)
+	expect02(master.WriteWo(1, 5, 1))
+	_, err = master.ReadDo(1, 4)
+	expect02(err)
+	master = check(modbus.ReadRegisterMapYaml(strings.NewReader(yaml)))
+	word1, err := master.ReadWi(2, 1)
+	assertWordEqualErr(t, err, word1, 0x1234)
+	bool1, err = master.ReadDi(2, 0)
+	assertBoolEqualErr(t, err, bool1, true)
+	_, err = modbus.ReadRegisterMapCsv(strings.NewReader("area,address,type\nhr,0,u32\nhr,1,u16\n"))
+	if err == nil {
+		t.Fatalf("overlap error expected")
+	}
+	_, err = modbus.ReadRegisterMapCsv(strings.NewReader("slave,area,address\n0,hr,0\n"))
+	if err == nil {
+		t.Fatalf("slave 0 error expected")
+	}
+	_, err = modbus.ReadRegisterMapYaml(strings.NewReader("- {slave: 0, area: hr, address: 0}\n"))
+	if err == nil {
+		t.Fatalf("slave 0 error expected")
+	}
+}
+
+func TestAccessPolicy(t *testing.T) {
+	audits := []*modbus.AuditEntry{}
+	policy := &modbus.AccessPolicy{Audit: func(entry *modbus.AuditEntry) {
+		audits = append(audits, entry)
+	}}
+	policy.Rules = append(policy.Rules,
+		&modbus.AccessRule{Slave: 1, Area: modbus.ReadWos03, Address: 0, Count: 10, Access: modbus.AccessReadOnly},
+		&modbus.AccessRule{Slave: 1, Area: modbus.ReadWos03, Address: 20, Count: 10, Access: modbus.AccessHidden},
+		&modbus.AccessRule{Slave: 1, Area: modbus.ReadDos01, WriteIPs: []string{"10.0.0.0/8"}, WriteRoles: []string{"operator"}},
+	)
+	exec := modbus.NewModelExecutor(modbus.NewMapModel())
+	expect := func(err error, code byte) {
+		if me, ok := err.(*modbus.ModbusException); !ok || me.Code != code {
+			t.Fatalf("exception %02x expected: %v", code, err)
+		}
+	}
+	guest := modbus.NewCloseableMaster(modbus.NewAccessExecutor(exec, policy, &modbus.Client{IP: net.ParseIP("192.168.1.2")}), nil)
+	_, err := guest.ReadWos(1, 0, 10)
+	fatalIfError(t, err)
+	expect(guest.WriteWos(1, 9, 1, 2), modbus.ExIllegalAddress02)
+	fatalIfError(t, guest.WriteWos(1, 10, 1, 2))
+	_, err = guest.ReadWos(1, 15, 10)
+	expect(err, modbus.ExIllegalAddress02)
+	expect(guest.WriteDo(1, 0, true), modbus.ExIllegalFunction01)
+	lan := modbus.NewCloseableMaster(modbus.NewAccessExecutor(exec, policy, &modbus.Client{IP: net.ParseIP("10.1.2.3")}), nil)
+	fatalIfError(t, lan.WriteDo(1, 0, true))
+	operator := &modbus.Client{IP: net.ParseIP("192.168.1.2"), Roles: []string{"operator"}}
+	fatalIfError(t, modbus.NewCloseableMaster(modbus.NewAccessExecutor(exec, policy, operator), nil).WriteDo(1, 0, true))
+	if len(audits) != 3 || audits[2].Reason != "client not allowed" {
+		t.Fatalf("audits mismatch got %d", len(audits))
+	}
+	entries := []*modbus.LogEntry{}
+	logged := &modbus.AccessPolicy{Rules: policy.Rules, Logger: modbus.NewFuncLogger(func(entry *modbus.LogEntry) {
+		entries = append(entries, entry)
+	})}
+	guest = modbus.NewCloseableMaster(modbus.NewAccessExecutor(exec, logged, &modbus.Client{IP: net.ParseIP("192.168.1.2")}), nil)
+	expect(guest.WriteDo(1, 0, true), modbus.ExIllegalFunction01)
+	if len(entries) != 1 || entries[0].Source != "a" || !strings.Contains(entries[0].String(), "client not allowed") {
+		t.Fatalf("log entries mismatch %v", entries)
+	}
+}
+
+type failExecutor struct{}
+
+func (e *failExecutor) Execute(ci *modbus.Command) (*modbus.Command, error) {
+	return nil, fmt.Errorf("crc mismatch")
+}
+
+func TestGateway(t *testing.T) {
+	router := modbus.NewRtuRouter()
+	router.RouteModel(1, modbus.NewMapModel())
+	dconn, gconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewRtuProtocol(), modbus.NewConnTransport(dconn), router)
+	gateway := modbus.NewRtuGateway(modbus.NewConnTransport(gconn), 200)
+	defer gateway.Close()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfError(t, err)
+	server := modbus.NewTcpServer(gateway, modbus.ServerOptions{})
+	defer server.Close()
+	go server.Serve(listen)
+	trans, err := modbus.NewTcpTransport(listen.Addr().String(), 400)
+	fatalIfError(t, err)
+	master := modbus.NewTcpMaster(trans, 800)
+	defer master.Close()
+	fatalIfError(t, master.WriteWo(1, 0, 0x1234))
+	word1, err := master.ReadWo(1, 0)
+	assertWordEqualErr(t, err, word1, 0x1234)
+	err = master.WriteWo(2, 0, 0)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExGatewayTarget0B {
+		t.Fatalf("exception expected: %v", err)
+	}
+	word1, err = master.ReadWo(1, 0)
+	assertWordEqualErr(t, err, word1, 0x1234)
+	err = master.WriteWo(0, 0, 0)
+	if !errors.Is(err, modbus.ErrTimeout) {
+		t.Fatalf("timeout expected: %v", err)
+	}
+	word1, err = master.ReadWo(1, 0)
+	assertWordEqualErr(t, err, word1, 0x1234)
+	_, err = modbus.NewGatewayExecutor(&failExecutor{}).Execute(&modbus.Command{Slave: 0, Code: modbus.WriteWo06})
+	if !errors.Is(err, modbus.ErrNoResponse) {
+		t.Fatalf("no response expected: %v", err)
+	}
+	err = modbus.NewCloseableMaster(modbus.NewGatewayExecutor(&failExecutor{}), nil).WriteWo(1, 0, 0)
+	if me, ok := err.(*modbus.ModbusException); !ok || me.Code != modbus.ExGatewayPath0A {
+		t.Fatalf("exception expected: %v", err)
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	run := func(seed int64) (modbus.Model, *modbus.FakeClock, *modbus.Simulator) {
+		model := modbus.NewMapModel()
+		clock := modbus.NewFakeClock(time.Unix(0, 0))
+		sim := modbus.NewSimulator(model, clock, seed)
+		gens := []*modbus.Generator{
+			{Slave: 1, Area: modbus.ReadWos03, Address: 0, Kind: modbus.GenRamp, Max: 100, Period: 10 * time.Second},
+			{Slave: 1, Area: modbus.ReadDos01, Address: 0, Kind: modbus.GenSquare, Max: 1, Period: 2 * time.Second},
+			{Slave: 1, Area: modbus.ReadWis04, Address: 0, Kind: modbus.GenCounter, Max: 3, Step: 1, Period: time.Second},
+			{Slave: 1, Area: modbus.ReadWos03, Address: 1, Kind: modbus.GenLag, Tau: time.Second,
+				Source: modbus.ModelRange{Slave: 1, Area: modbus.ReadWos03, Address: 5}},
+			{Slave: 1, Area: modbus.ReadWis04, Address: 1, Kind: modbus.GenWalk, Min: -50, Max: 50, Step: 5, Type: modbus.TypeI16},
+		}
+		for _, gen := range gens {
+			fatalIfError(t, sim.Add(gen))
+		}
+		return model, clock, sim
+	}
+	model, clock, sim := run(7)
+	model.WriteWos(1, 5, 1000)
+	sim.Step()
+	clock.Advance(2500 * time.Millisecond)
+	sim.Step()
+	assertWordsEqual(t, model.ReadWos(1, 0, 2), []uint16{25, 918})
+	assertBoolsEqual(t, model.ReadDos(1, 0, 1), []bool{true})
+	clock.Advance(2500 * time.Millisecond)
+	sim.Step()
+	assertWordsEqual(t, model.ReadWis(1, 0, 1), []uint16{1})
+	assertBoolsEqual(t, model.ReadDos(1, 0, 1), []bool{false})
+	walk := model.ReadWis(1, 1, 1)
+	other, clock, sim := run(7)
+	for i := 0; i < 3; i++ {
+		sim.Step()
+		clock.Advance(time.Second)
+	}
+	assertWordsEqual(t, other.ReadWis(1, 1, 1), walk)
+	err := sim.Add(&modbus.Generator{Area: modbus.ReadWos03, Kind: "noise"})
+	if err == nil {
+		t.Fatalf("generator error expected")
+	}
+	fatalIfError(t, sim.Add(&modbus.Generator{Slave: 2, Area: modbus.ReadWos03, Kind: modbus.GenSquare,
+		Min: -5, Max: 70000, Period: 2 * time.Second}))
+	sim.Step()
+	assertWordsEqual(t, other.ReadWos(2, 0, 1), []uint16{0})
+	clock.Advance(time.Second)
+	sim.Step()
+	assertWordsEqual(t, other.ReadWos(2, 0, 1), []uint16{0xFFFF})
+	if sim.Start(0) == nil {
+		t.Fatalf("interval error expected")
+	}
+}
+
+func TestBehavior(t *testing.T) {
+	opts := modbus.BehaviorOptions{Seed: 1}
+	opts.Behaviors = append(opts.Behaviors,
+		&modbus.Behavior{Slave: 2, Delay: 300 * time.Millisecond},
+		&modbus.Behavior{Code: modbus.WriteWo06, NoResponse: 1},
+		&modbus.Behavior{Slave: 1, Delay: 10 * time.Millisecond, Jitter: 10 * time.Millisecond, BusyEvery: 2, AckEvery: 3},
+	)
+	exec := modbus.NewBehaviorExecutor(modbus.NewModelExecutor(modbus.NewMapModel()), opts)
+	sconn, mconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), exec)
+	master := modbus.NewTcpMaster(modbus.NewConnTransport(mconn), 200)
+	defer master.Close()
+	expect := func(err error, code byte) {
+		if me, ok := err.(*modbus.ModbusException); !ok || me.Code != code {
+			t.Fatalf("exception %02x expected: %v", code, err)
+		}
+	}
+	_, err := master.ReadWo(1, 0)
+	fatalIfError(t, err)
+	_, err = master.ReadWo(1, 0)
+	expect(err, modbus.ExBusy06)
+	_, err = master.ReadWo(1, 0)
+	expect(err, modbus.ExAcknowledge05)
+	err = master.WriteWo(1, 0, 1)
+	if !errors.Is(err, modbus.ErrTimeout) {
+		t.Fatalf("timeout expected: %v", err)
+	}
+	_, err = master.ReadWo(2, 0)
+	if !errors.Is(err, modbus.ErrTimeout) {
+		t.Fatalf("timeout expected: %v", err)
+	}
+	//late response discarded and fourth command busy
+	time.Sleep(200 * time.Millisecond)
+	_, err = master.ReadWo(1, 0)
+	expect(err, modbus.ExBusy06)
+}
+
+func TestMultidrop(t *testing.T) {
+	model := modbus.NewMapModel()
+	sconn, mconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewRtuMultidropProtocol(1, 3), modbus.NewConnTransport(sconn), modbus.NewModelExecutor(model))
+	proto := modbus.NewRtuProtocol()
+	wrap := func(pdu ...byte) []byte {
+		frame, buf := proto.MakeBuffers(uint16(len(pdu)))
+		copy(buf, pdu)
+		proto.WrapBuffer(frame, uint16(len(pdu)))
+		return frame
+	}
+	other := wrap(2, modbus.WriteWo06, 0, 0, 0, 7)
+	response := wrap(2, modbus.ReadWos03, 2, 0, 7)
+	corrupt := wrap(1, modbus.WriteWo06, 0, 0, 0, 9)
+	corrupt[len(corrupt)-1] ^= 0x01
+	broadcast := wrap(0, modbus.WriteWo06, 0, 1, 0, 8)
+	bus := append([]byte{0x00, 0xFF, 0x13}, other...)
+	bus = append(bus, response...)
+	bus = append(bus, corrupt...)
+	bus = append(bus, broadcast...)
+	bus = append(bus, wrap(0, modbus.ReadWos03, 0, 0, 0, 1)...)
+	_, err := mconn.Write(bus)
+	fatalIfError(t, err)
+	master := modbus.NewRtuMaster(modbus.NewConnTransport(mconn), 200)
+	defer master.Close()
+	fatalIfError(t, master.WriteWo(1, 0, 5))
+	assertWordsEqual(t, model.ReadWos(1, 0, 1), []uint16{5})
+	assertWordsEqual(t, model.ReadWos(2, 0, 1), []uint16{0})
+	assertWordsEqual(t, model.ReadWos(0, 1, 1), []uint16{8})
+	fatalIfError(t, master.WriteWos(3, 0, 1, 2, 3))
+	words, err := master.ReadWos(3, 0, 3)
+	assertWordsEqualErr(t, err, words, []uint16{1, 2, 3})
+	_, err = master.ReadWo(2, 0)
+	if !errors.Is(err, modbus.ErrTimeout) {
+		t.Fatalf("timeout expected: %v", err)
+	}
+	word1, err := master.ReadWo(1, 0)
+	assertWordEqualErr(t, err, word1, 5)
+}
+
+func TestSlaveFraming(t *testing.T) {
+	sconn, mconn := net.Pipe()
+	exec := modbus.NewModelExecutor(modbus.NewMapModel())
+	slave := modbus.NewSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), exec, modbus.SlaveOptions{})
+	done := make(chan error, 1)
+	go func() { done <- slave.Run() }()
+	//proto mismatch then wait for the discard to drain the body
+	_, err := mconn.Write([]byte{0, 1, 0, 5, 0, 6, 1, 3, 0, 0, 0, 1})
+	fatalIfError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	_, err = mconn.Write([]byte{0, 2, 0, 0, 0, 6, 1, 0x2B, 0, 0, 0, 0})
+	fatalIfError(t, err)
+	res := make([]byte, 9)
+	_, err = io.ReadFull(mconn, res)
+	fatalIfError(t, err)
+	if !bytes.Equal(res, []byte{0, 2, 0, 0, 0, 3, 1, 0xAB, modbus.ExIllegalFunction01}) {
+		t.Fatalf("exception mismatch %x", res)
+	}
+	master := modbus.NewTcpMaster(modbus.NewConnTransport(mconn), 400)
+	_, err = master.ReadWo(1, 0)
+	fatalIfError(t, err)
+	master.Close()
+	if err = <-done; errors.Is(err, modbus.ErrFraming) {
+		t.Fatalf("io error expected: %v", err)
+	}
+	stats := slave.Stats()
+	if stats.Framing != 1 || stats.Exceptions != 1 || stats.Requests != 2 {
+		t.Fatalf("stats mismatch %+v", stats)
+	}
+	sconn, mconn = net.Pipe()
+	defer mconn.Close()
+	entries := []*modbus.LogEntry{}
+	logger := modbus.NewFuncLogger(func(entry *modbus.LogEntry) { entries = append(entries, entry) })
+	opts := modbus.SlaveOptions{Framing: modbus.FramingClose, Logger: logger}
+	slave = modbus.NewSlave(modbus.NewRtuProtocol(), modbus.NewConnTransport(sconn), exec, opts)
+	go func() { done <- slave.Run() }()
+	_, err = mconn.Write([]byte{1, 3, 0, 0, 0, 1, 0, 0})
+	fatalIfError(t, err)
+	if err = <-done; !errors.Is(err, modbus.ErrFraming) {
+		t.Fatalf("framing error expected: %v", err)
+	}
+	if len(entries) != 1 || !errors.Is(entries[0].Err, modbus.ErrFraming) {
+		t.Fatalf("entries mismatch %v", entries)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	model := modbus.NewMapModel()
+	panics := 0
+	inner := modbus.ExecutorFunc(func(ci *modbus.Command) (*modbus.Command, error) {
+		if ci.Address == 0xFF {
+			panic("boom")
+		}
+		return modbus.NewModelExecutor(model).Execute(ci)
+	})
+	slaveExec := modbus.Chain(inner,
+		modbus.RecoverMiddleware(func(ci *modbus.Command, value interface{}) { panics++ }),
+		modbus.AllowMiddleware(modbus.ReadWos03, modbus.WriteWo06),
+		modbus.ValidateMiddleware(),
+	)
+	sconn, mconn := net.Pipe()
+	go modbus.RunSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), slaveExec)
+	entries := []*modbus.LogEntry{}
+	timed := 0
+	masterExec := modbus.Chain(modbus.NewTransportExecutor(modbus.NewTcpProtocol(), modbus.NewConnTransport(mconn), 400),
+		modbus.LoggingMiddleware(modbus.NewFuncLogger(func(entry *modbus.LogEntry) { entries = append(entries, entry) })),
+		modbus.TimingMiddleware(func(ci *modbus.Command, elapsed time.Duration, err error) { timed++ }),
+	)
+	master := modbus.NewCloseableMaster(masterExec, mconn)
+	defer master.Close()
+	expect := func(err error, code byte) {
+		if me, ok := err.(*modbus.ModbusException); !ok || me.Code != code {
+			t.Fatalf("exception %02x expected: %v", code, err)
+		}
+	}
+	fatalIfError(t, master.WriteWo(1, 0, 7))
+	word1, err := master.ReadWo(1, 0)
+	assertWordEqualErr(t, err, word1, 7)
+	expect(master.WriteDo(1, 0, true), modbus.ExIllegalFunction01)
+	_, err = master.ReadWo(1, 0xFF)
+	expect(err, modbus.ExDeviceFailure04)
+	_, err = master.ReadWos(1, 0xFFFF, 2)
+	expect(err, modbus.ExIllegalAddress02)
+	if panics != 1 || timed != 5 || len(entries) != 10 || entries[0].Source != "m" {
+		t.Fatalf("middleware mismatch panics=%d timed=%d entries=%d", panics, timed, len(entries))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	registry := modbus.NewMetricsRegistry()
+	sconn, mconn := net.Pipe()
+	exec := modbus.NewModelExecutor(modbus.NewMapModel())
+	opts := modbus.SlaveOptions{Metrics: registry.Device("sim")}
+	go modbus.NewSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), exec, opts).Run()
+	master := modbus.NewTcpMaster(modbus.NewConnTransport(mconn), 400)
+	defer master.Close()
+	master.(modbus.Measurable).SetMetrics(registry.Device("plc"))
+	fatalIfError(t, master.WriteWo(1, 0, 1))
+	_, err := master.ReadWos(1, 0, 2)
+	fatalIfError(t, err)
+	_, err = master.ReadWos(1, 0, 200)
+	if err == nil {
+		t.Fatalf("count error expected")
+	}
+	//unsupported codes are answered before the executor
+	rconn, sconn := net.Pipe()
+	go modbus.NewSlave(modbus.NewTcpProtocol(), modbus.NewConnTransport(sconn), exec, opts).Run()
+	defer rconn.Close()
+	_, err = rconn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 0x2B, 0, 0, 0, 0})
+	fatalIfError(t, err)
+	reply := make([]byte, 9)
+	_, err = io.ReadFull(rconn, reply)
+	fatalIfError(t, err)
+	if reply[7] != 0x2B|0x80 || reply[8] != modbus.ExIllegalFunction01 {
+		t.Fatalf("exception reply expected % x", reply)
+	}
+	registry.Device("plc").Reconnect()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	fatalIfError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	fatalIfError(t, err)
+	text := string(body)
+	for _, line := range []string{
+		`modbus_requests_total{device="plc",side="master",unit="1",function="ReadWos03"} 2`,
+		`modbus_requests_total{device="sim",side="slave",unit="1",function="WriteWo06"} 1`,
+		`modbus_errors_total{device="plc",side="master",unit="1",function="ReadWos03",class="protocol"} 1`,
+		`modbus_request_duration_seconds_count{device="plc",side="master",unit="1",function="WriteWo06"} 1`,
+		`modbus_bytes_total{device="plc",direction="out"} 24`,
+		`modbus_reconnects_total{device="plc"} 1`,
+		`modbus_framing_errors_total{device="sim"} 0`,
+		`modbus_exceptions_total{device="sim",side="slave",unit="1",function="2b",code="01"} 1`,
+		`# TYPE modbus_request_duration_seconds histogram`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("line missing %s\n%s", line, text)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	proto := modbus.NewRtuProtocol()
+	wrap := func(pdu ...byte) []byte {
+		frame, buf := proto.MakeBuffers(uint16(len(pdu)))
+		copy(buf, pdu)
+		proto.WrapBuffer(frame, uint16(len(pdu)))
+		return frame
+	}
+	request := wrap(1, modbus.ReadWos03, 0, 0, 0, 2)
+	response := wrap(1, modbus.ReadWos03, 4, 0, 1, 0, 2)
+	corrupt := wrap(1, modbus.WriteWo06, 0, 3, 0, 9)
+	corrupt[len(corrupt)-1] ^= 0x01
+	exception := wrap(1, modbus.WriteWo06|0x80, modbus.ExIllegalAddress02)
+	rtu, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: modbus.FramingRtu})
+	fatalIfError(t, err)
+	frames := rtu.Feed(time.Time{}, request)
+	frames = append(frames, rtu.Feed(time.Time{}, response[:3])...)
+	frames = append(frames, rtu.Feed(time.Time{}, response[3:])...)
+	frames = append(frames, rtu.Feed(time.Time{}, append([]byte{0xFF, 0x13}, corrupt...))...)
+	frames = append(frames, rtu.Feed(time.Time{}, exception)...)
+	frames = append(frames, rtu.Flush()...)
+	if len(frames) != 5 {
+		t.Fatalf("frames %v", frames)
+	}
+	if frames[0].Response || frames[0].Command.Corv != 2 {
+		t.Fatalf("request %v", frames[0])
+	}
+	if !frames[1].Response || frames[1].Request != frames[0] || len(frames[1].Issues) > 0 {
+		t.Fatalf("response %v", frames[1])
+	}
+	assertWordsEqual(t, frames[1].Command.Words, []uint16{1, 2})
+	if frames[2].Command != nil || frames[2].Issues[0] != "unparsed 2 bytes" {
+		t.Fatalf("garbage %v", frames[2])
+	}
+	if frames[3].Command.Corv != 9 || !strings.HasPrefix(frames[3].Issues[0], "crc mismatch") {
+		t.Fatalf("corrupt %v", frames[3])
+	}
+	if frames[4].Request != frames[3] || frames[4].Exception != modbus.ExIllegalAddress02 {
+		t.Fatalf("exception %v", frames[4])
+	}
+	//9600 baud t3.5 is 4ms and t1.5 is 1.7ms
+	timed, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: modbus.FramingRtu, Baud: 9600})
+	fatalIfError(t, err)
+	start := time.Now()
+	char := 11 * time.Second / 9600
+	at := func(bytes int, extra time.Duration) time.Time {
+		return start.Add(time.Duration(bytes)*char + extra)
+	}
+	frames = timed.Feed(start, request)
+	frames = append(frames, timed.Feed(at(8, time.Millisecond), response[:4])...)
+	frames = append(frames, timed.Feed(at(12, 4*time.Millisecond), response[4:])...)
+	frames = append(frames, timed.Flush()...)
+	if len(frames) != 2 || len(frames[0].Issues) > 0 || len(frames[1].Issues) != 2 {
+		t.Fatalf("timed %v", frames)
+	}
+	if !strings.HasPrefix(frames[1].Issues[0], "silence") || !strings.HasPrefix(frames[1].Issues[1], "gap") {
+		t.Fatalf("timing %v", frames[1].Issues)
+	}
+	ascii, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: modbus.FramingAscii})
+	fatalIfError(t, err)
+	frames = ascii.Feed(time.Time{}, []byte("\x00:010300000002FA\r\n:01030400010002F6\r\n"))
+	if len(frames) != 3 || frames[0].Command != nil || frames[2].Request != frames[1] {
+		t.Fatalf("ascii %v", frames)
+	}
+	if len(frames[2].Issues) != 1 || frames[2].Issues[0] != "lrc mismatch got f6 expected f5" {
+		t.Fatalf("lrc %v", frames[2].Issues)
+	}
+	tcp, err := modbus.NewDecoder(modbus.DecoderOptions{Framing: modbus.FramingTcp})
+	fatalIfError(t, err)
+	frames = tcp.Feed(time.Time{}, []byte{0, 5, 0, 0, 0, 6, 1, 2, 0, 0, 0, 3, 0, 6, 0, 0, 0, 4, 1, 2, 1})
+	frames = append(frames, tcp.Feed(time.Time{}, []byte{0x05, 0, 5, 0, 0, 0, 4, 1, 2, 1, 0x02})...)
+	if len(frames) != 3 || frames[1].Tid != 6 || frames[2].Request != frames[0] {
+		t.Fatalf("tcp %v", frames)
+	}
+	if len(frames[1].Issues) != 1 || frames[1].Issues[0] != "response without request" {
+		t.Fatalf("tcp unpaired %v", frames[1].Issues)
+	}
+	assertBoolsEqual(t, frames[2].Command.Bools, []bool{false, true, false})
+}
+
+func TestBridge(t *testing.T) {
+	model := modbus.NewMapModel()
+	exec := modbus.Chain(modbus.NewModelExecutor(model), modbus.ValidateMiddleware())
+	master := modbus.NewCloseableMaster(exec, nil)
+	bridge := modbus.NewBridge()
+	bridge.Add("plc", master, 1)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		fatalIfError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		fatalIfError(t, err)
+		defer res.Body.Close()
+		obj := map[string]interface{}{}
+		fatalIfError(t, json.NewDecoder(res.Body).Decode(&obj))
+		return res.StatusCode, obj
+	}
+	status, obj := call("PUT", "/devices/plc/hr/10?type=f32&order=CDAB", `{"values": [1.5, -2]}`)
+	if status != http.StatusOK {
+		t.Fatalf("put %d %v", status, obj)
+	}
+	assertWordsEqual(t, model.ReadWos(1, 10, 4), []uint16{0, 0x3FC0, 0, 0xC000})
+	status, obj = call("GET", "/devices/plc/hr/10?count=2&type=f32&order=CDAB", "")
+	if status != http.StatusOK || fmt.Sprint(obj["values"]) != "[1.5 -2]" {
+		t.Fatalf("get %d %v", status, obj)
+	}
+	status, obj = call("PUT", "/devices/plc/coil/3?unit=2", "true")
+	if status != http.StatusOK || !model.ReadDos(2, 3, 1)[0] {
+		t.Fatalf("coil %d %v", status, obj)
+	}
+	status, obj = call("GET", "/devices/plc/hr/65535?count=2", "")
+	if status != http.StatusNotFound || obj["exception"] != "02" {
+		t.Fatalf("exception %d %v", status, obj)
+	}
+	status, _ = call("PUT", "/devices/plc/ir/0", "1")
+	if status != http.StatusMethodNotAllowed {
+		t.Fatalf("read only %d", status)
+	}
+	status, _ = call("GET", "/devices/plc/hr/0?count=200", "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("count %d", status)
+	}
+	status, _ = call("GET", "/devices/other/hr/0", "")
+	if status != http.StatusNotFound {
+		t.Fatalf("device %d", status)
+	}
+	status, obj = call("GET", "/devices", "")
+	if status != http.StatusOK || fmt.Sprint(obj["devices"]) != "[plc]" {
+		t.Fatalf("devices %d %v", status, obj)
+	}
+	model.WriteWos(1, 30, 0x7FC0, 0, 0x7F80, 0)
+	status, obj = call("GET", "/devices/plc/hr/30?count=2&type=f32", "")
+	if status != http.StatusOK || fmt.Sprint(obj["values"]) != "[<nil> <nil>]" {
+		t.Fatalf("nan %d %v", status, obj)
+	}
+	status, obj = call("PUT", "/devices/plc/hr/40?type=string", `""`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("empty %d %v", status, obj)
+	}
+	if status := modbus.BridgeStatus(&modbus.ModbusException{Code: modbus.ExAcknowledge05}); status != http.StatusServiceUnavailable {
+		t.Fatalf("acknowledge %d", status)
+	}
+}
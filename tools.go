@@ -1,8 +1,11 @@
 package modbus
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"runtime/debug"
 	"time"
@@ -16,6 +19,7 @@ func init() {
 	}
 }
 
+// Deprecated: use a Logger through SetLogger
 func Trace(args ...interface{}) {
 	if traceEnabled {
 		log.Println(args...)
@@ -34,3 +38,33 @@ func formatErr(format string, args ...interface{}) error {
 	msg := fmt.Sprintf(format, args...)
 	return fmt.Errorf("%s %s", msg, string(debug.Stack()))
 }
+
+// Error text without the stack trace appended by the library
+func ErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return firstLine(err.Error())
+}
+
+// Closed connections must end reads instead of retrying
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, os.ErrClosed)
+}
+
+// I/O errors end sessions, timeouts and malformed data do not
+func isIoErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || isClosedErr(err) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return !ne.Timeout()
+	}
+	return false
+}
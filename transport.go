@@ -9,6 +9,20 @@ type ioTransport struct {
 	writer  io.Writer
 	reader  TimedReader
 	discard bool
+	logger  Logger
+	metrics MetricsHook
+}
+
+func (t *ioTransport) SetLogger(logger Logger) {
+	t.logger = logger
+}
+
+func (t *ioTransport) Logger() Logger {
+	return t.logger
+}
+
+func (t *ioTransport) SetMetrics(hook MetricsHook) {
+	t.metrics = hook
 }
 
 func (t *ioTransport) Close() (err error) {
@@ -38,6 +52,14 @@ func (t *ioTransport) DiscardIf() (err error) {
 }
 
 func (t *ioTransport) TimedRead(buf []byte, toms int) (count int, err error) {
+	defer func() {
+		if t.logger != nil {
+			logFrame(t.logger, "io", "<", buf[:count], nil, err)
+		}
+		if t.metrics != nil && count > 0 {
+			t.metrics.Bytes("<", count)
+		}
+	}()
 	toms64 := int64(toms)
 	start := unixMillis()
 	total := len(buf)
@@ -55,7 +77,7 @@ func (t *ioTransport) TimedRead(buf []byte, toms int) (count int, err error) {
 				return
 			}
 		}
-		if err == io.EOF {
+		if isIoErr(err) {
 			return
 		}
 		//keep reading, ignore timeout if readc > 0
@@ -63,6 +85,9 @@ func (t *ioTransport) TimedRead(buf []byte, toms int) (count int, err error) {
 			now := unixMillis()
 			if now-start >= toms64 {
 				err = formatErr("read total timeout %d of %d", count, total)
+				if count == 0 {
+					err = &timeoutError{err}
+				}
 				return
 			}
 		}
@@ -72,5 +97,11 @@ func (t *ioTransport) TimedRead(buf []byte, toms int) (count int, err error) {
 
 func (t *ioTransport) Write(buf []byte) (c int, err error) {
 	c, err = t.writer.Write(buf)
+	if t.logger != nil {
+		logFrame(t.logger, "io", ">", buf, nil, err)
+	}
+	if t.metrics != nil && c > 0 {
+		t.metrics.Bytes(">", c)
+	}
 	return
 }
@@ -0,0 +1,318 @@
+package modbus
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Register data types
+const (
+	TypeBool   = "bool"
+	TypeU16    = "u16"
+	TypeI16    = "i16"
+	TypeU32    = "u32"
+	TypeI32    = "i32"
+	TypeF32    = "f32"
+	TypeU64    = "u64"
+	TypeI64    = "i64"
+	TypeF64    = "f64"
+	TypeString = "string"
+)
+
+// Byte orders named after the big endian ABCD sequence.
+// CDAB swaps words, BADC swaps bytes within words
+// and DCBA does both.
+const (
+	OrderABCD = "ABCD"
+	OrderCDAB = "CDAB"
+	OrderBADC = "BADC"
+	OrderDCBA = "DCBA"
+)
+
+// Words taken by a single value, 0 for strings and bools
+func TypeWords(dtype string) int {
+	switch dtype {
+	case TypeU16, TypeI16:
+		return 1
+	case TypeU32, TypeI32, TypeF32:
+		return 2
+	case TypeU64, TypeI64, TypeF64:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func CheckType(dtype string) error {
+	switch dtype {
+	case TypeBool, TypeString:
+		return nil
+	}
+	if TypeWords(dtype) == 0 {
+		return formatErr("type unsupported %s", dtype)
+	}
+	return nil
+}
+
+// Empty order defaults to ABCD
+func CheckOrder(order string) error {
+	_, _, err := parseOrder(order)
+	return err
+}
+
+// Parses a literal for the type, integers accept 0x prefixes
+func ParseValue(dtype string, text string) (value interface{}, err error) {
+	text = strings.TrimSpace(text)
+	switch dtype {
+	case TypeBool:
+		switch strings.ToLower(text) {
+		case "", "0", "false", "off":
+			return false, nil
+		case "1", "true", "on":
+			return true, nil
+		}
+		return nil, formatErr("bool invalid %q", text)
+	case TypeString:
+		return text, nil
+	case TypeF32, TypeF64:
+		if text == "" {
+			return float64(0), nil
+		}
+		return strconv.ParseFloat(text, 64)
+	case TypeI16, TypeI32, TypeI64:
+		if text == "" {
+			return int64(0), nil
+		}
+		return strconv.ParseInt(text, 0, 64)
+	case TypeU16, TypeU32, TypeU64:
+		if text == "" {
+			return uint64(0), nil
+		}
+		return strconv.ParseUint(text, 0, 64)
+	}
+	return nil, formatErr("type unsupported %s", dtype)
+}
+
+// Packs a numeric value into words, accepts any Go integer or float
+func EncodeValue(dtype string, order string, value interface{}) ([]uint16, error) {
+	size := TypeWords(dtype)
+	if size == 0 {
+		return nil, formatErr("type unsupported %s", dtype)
+	}
+	var bits uint64
+	switch dtype {
+	case TypeF32:
+		f, err := toFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		bits = uint64(math.Float32bits(float32(f)))
+	case TypeF64:
+		f, err := toFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		bits = math.Float64bits(f)
+	case TypeI16, TypeI32, TypeI64:
+		i, err := toInt(value)
+		if err != nil {
+			return nil, err
+		}
+		if !fitsInt(i, size*16) {
+			return nil, formatErr("value %d out of range for %s", i, dtype)
+		}
+		bits = uint64(i)
+	default:
+		u, err := toUint(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 4 && u>>(size*16) != 0 {
+			return nil, formatErr("value %d out of range for %s", u, dtype)
+		}
+		bits = u
+	}
+	words := make([]uint16, size)
+	for i := range words {
+		words[size-1-i] = uint16(bits >> (16 * i))
+	}
+	return applyOrder(order, words)
+}
+
+// Unpacks words into uint64, int64 or float64 by type
+func DecodeValue(dtype string, order string, words []uint16) (interface{}, error) {
+	size := TypeWords(dtype)
+	if size == 0 {
+		return nil, formatErr("type unsupported %s", dtype)
+	}
+	if len(words) != size {
+		return nil, formatErr("word count mismatch got %d expected %d", len(words), size)
+	}
+	ordered, err := applyOrder(order, words)
+	if err != nil {
+		return nil, err
+	}
+	var bits uint64
+	for _, w := range ordered {
+		bits = bits<<16 | uint64(w)
+	}
+	switch dtype {
+	case TypeF32:
+		return float64(math.Float32frombits(uint32(bits))), nil
+	case TypeF64:
+		return math.Float64frombits(bits), nil
+	case TypeI16:
+		return int64(int16(bits)), nil
+	case TypeI32:
+		return int64(int32(bits)), nil
+	case TypeI64:
+		return int64(bits), nil
+	default:
+		return bits, nil
+	}
+}
+
+// Two chars per word padded with zeros, byte swapped for BADC and DCBA
+func EncodeString(order string, text string, size int) ([]uint16, error) {
+	_, byteSwap, err := parseOrder(order)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) > 2*size {
+		return nil, formatErr("string length %d exceeds %d words", len(text), size)
+	}
+	buf := make([]byte, 2*size)
+	copy(buf, text)
+	words := make([]uint16, size)
+	for i := range words {
+		if byteSwap {
+			words[i] = encodeWord(buf[2*i+1], buf[2*i])
+		} else {
+			words[i] = encodeWord(buf[2*i], buf[2*i+1])
+		}
+	}
+	return words, nil
+}
+
+// Trailing zeros are removed
+func DecodeString(order string, words []uint16) (string, error) {
+	_, byteSwap, err := parseOrder(order)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2*len(words))
+	for i, w := range words {
+		if byteSwap {
+			buf[2*i], buf[2*i+1] = lowByte(w), highByte(w)
+		} else {
+			buf[2*i], buf[2*i+1] = highByte(w), lowByte(w)
+		}
+	}
+	return strings.TrimRight(string(buf), "\x00"), nil
+}
+
+// Its own inverse so it serves encoding and decoding
+func applyOrder(order string, words []uint16) ([]uint16, error) {
+	wordSwap, byteSwap, err := parseOrder(order)
+	if err != nil {
+		return nil, err
+	}
+	size := len(words)
+	out := make([]uint16, size)
+	for i, w := range words {
+		if byteSwap {
+			w = w<<8 | w>>8
+		}
+		if wordSwap {
+			out[size-1-i] = w
+		} else {
+			out[i] = w
+		}
+	}
+	return out, nil
+}
+
+func parseOrder(order string) (wordSwap bool, byteSwap bool, err error) {
+	switch strings.ToUpper(order) {
+	case "", OrderABCD, "AB":
+		return false, false, nil
+	case OrderCDAB:
+		return true, false, nil
+	case OrderBADC, "BA":
+		return false, true, nil
+	case OrderDCBA:
+		return true, true, nil
+	}
+	return false, false, formatErr("order unsupported %s", order)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	}
+	i, err := toInt(value)
+	return float64(i), err
+}
+
+func toInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, formatErr("value %d out of range", v)
+		}
+		return int64(v), nil
+	case uint:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case float32:
+		return int64(v), nil
+	}
+	return 0, formatErr("value unsupported %T", value)
+}
+
+func toUint(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case uint64:
+		return v, nil
+	case uint:
+		return uint64(v), nil
+	}
+	i, err := toInt(value)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, formatErr("value %d out of range", i)
+	}
+	return uint64(i), nil
+}
+
+func fitsInt(i int64, bits int) bool {
+	if bits >= 64 {
+		return true
+	}
+	min := int64(-1) << (bits - 1)
+	max := -min - 1
+	return i >= min && i <= max
+}
@@ -0,0 +1,147 @@
+package modbus
+
+import (
+	"fmt"
+	"time"
+)
+
+// Zero values verify every write once without delay
+type VerifyOptions struct {
+	//delay before each read back
+	Settle time.Duration
+	//extra read backs before reporting a mismatch
+	Retries int
+	//returns true to skip verification of a write
+	Skip func(slave byte, code byte, address uint16) bool
+}
+
+// Returned when the read back does not match the written values
+type VerifyError struct {
+	Slave    byte
+	Code     byte
+	Address  uint16
+	Expected interface{}
+	Actual   interface{}
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verify mismatch unit %d %s address %04x wrote %v read %v",
+		e.Slave, CodeName(e.Code), e.Address, e.Expected, e.Actual)
+}
+
+// Implements: Master, CloseableMaster, Loggable, Measurable
+// Reads back after every write
+type verifyMaster struct {
+	CloseableMaster
+	opts VerifyOptions
+}
+
+// Applies to the inner master when loggable
+func (m *verifyMaster) SetLogger(logger Logger) {
+	if lt, ok := m.CloseableMaster.(Loggable); ok {
+		lt.SetLogger(logger)
+	}
+}
+
+func (m *verifyMaster) Logger() Logger {
+	return loggerOf(m.CloseableMaster)
+}
+
+// Applies to the inner master when measurable
+func (m *verifyMaster) SetMetrics(hook MetricsHook) {
+	if mt, ok := m.CloseableMaster.(Measurable); ok {
+		mt.SetMetrics(hook)
+	}
+}
+
+func (m *verifyMaster) WriteDo(slave byte, address uint16, value bool) (err error) {
+	err = m.CloseableMaster.WriteDo(slave, address, value)
+	if err != nil || m.skip(slave, WriteDo05, address) {
+		return
+	}
+	return m.verifyBools(slave, WriteDo05, address, []bool{value})
+}
+
+func (m *verifyMaster) WriteDos(slave byte, address uint16, values ...bool) (err error) {
+	err = m.CloseableMaster.WriteDos(slave, address, values...)
+	if err != nil || m.skip(slave, WriteDos15, address) {
+		return
+	}
+	return m.verifyBools(slave, WriteDos15, address, values)
+}
+
+func (m *verifyMaster) WriteWo(slave byte, address uint16, value uint16) (err error) {
+	err = m.CloseableMaster.WriteWo(slave, address, value)
+	if err != nil || m.skip(slave, WriteWo06, address) {
+		return
+	}
+	return m.verifyWords(slave, WriteWo06, address, []uint16{value})
+}
+
+func (m *verifyMaster) WriteWos(slave byte, address uint16, values ...uint16) (err error) {
+	err = m.CloseableMaster.WriteWos(slave, address, values...)
+	if err != nil || m.skip(slave, WriteWos16, address) {
+		return
+	}
+	return m.verifyWords(slave, WriteWos16, address, values)
+}
+
+func (m *verifyMaster) skip(slave byte, code byte, address uint16) bool {
+	return m.opts.Skip != nil && m.opts.Skip(slave, code, address)
+}
+
+func (m *verifyMaster) verifyBools(slave byte, code byte, address uint16, values []bool) (err error) {
+	var bools []bool
+	for i := 0; i <= m.opts.Retries; i++ {
+		time.Sleep(m.opts.Settle)
+		bools, err = m.CloseableMaster.ReadDos(slave, address, uint16(len(values)))
+		if err != nil {
+			return
+		}
+		if boolsEqual(bools, values) {
+			return
+		}
+	}
+	err = &VerifyError{slave, code, address, values, bools}
+	return
+}
+
+func (m *verifyMaster) verifyWords(slave byte, code byte, address uint16, values []uint16) (err error) {
+	var words []uint16
+	for i := 0; i <= m.opts.Retries; i++ {
+		time.Sleep(m.opts.Settle)
+		words, err = m.CloseableMaster.ReadWos(slave, address, uint16(len(values)))
+		if err != nil {
+			return
+		}
+		if wordsEqual(words, values) {
+			return
+		}
+	}
+	err = &VerifyError{slave, code, address, values, words}
+	return
+}
+
+func boolsEqual(a []bool, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func wordsEqual(a []uint16, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}